@@ -0,0 +1,70 @@
+// Command fakegraph serves a fake Graph API for local development. Point the server at it with
+// GRAPH_BASE_URL, and the OAuth dialog, token exchange, page discovery and posting all happen
+// against the fake instead of Facebook. DEV_DIR is required alongside it, keeping the server's
+// data in memory and its bucket objects in that directory so fake tokens and posts never reach
+// Firestore or Cloud Storage.
+//
+//	go run ./cmd/fakegraph -addr :8081 -fail media=rate_limit:2
+//	DEV_DIR=/tmp/weightlog GRAPH_BASE_URL=http://localhost:8081 IG_REDIRECT_URI=http://localhost:8080/new-token go run ./cmd/server
+//
+// Failures can also be scripted while it runs:
+//
+//	curl -X POST 'localhost:8081/_fake/fail?endpoint=media_publish&failure=token_expired'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/integrations/meta/fakegraph"
+)
+
+// failures collects repeated -fail flags
+type failures []string
+
+func (f *failures) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *failures) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+var (
+	addr          = flag.String("addr", ":8081", "address to listen on")
+	polls         = flag.Int("polls", 0, "status reads a new container stays IN_PROGRESS for")
+	tokenLifetime = flag.Duration("token-lifetime", 60*24*time.Hour, "how long long-lived tokens last")
+	fail          failures
+)
+
+func main() {
+	flag.Var(&fail, "fail", "script a failure as endpoint=failure[:times], repeatable")
+	flag.Parse()
+
+	s := fakegraph.New()
+	s.SetProcessingPolls(*polls)
+	s.TokenLifetime = *tokenLifetime
+
+	for _, v := range fail {
+		endpoint, rest, _ := strings.Cut(v, "=")
+		name, times, _ := strings.Cut(rest, ":")
+		e, f, n, err := fakegraph.ParseFailure(endpoint, name, times)
+		if err != nil {
+			fmt.Printf("error in -fail %q: %v\n", v, err)
+			os.Exit(2)
+		}
+		s.Fail(e, f, n)
+	}
+
+	fmt.Printf("fake Graph API listening on %s, page %q (%s), Instagram account %s\n", *addr, s.PageName, s.PageId, s.IgId)
+	err := http.ListenAndServe(*addr, s)
+	if err != nil {
+		fmt.Println("error serving:", err)
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,32 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/gcs"
 	"github.com/baely/weightloss-tracker/internal/server"
+	"github.com/baely/weightloss-tracker/internal/util"
 )
 
 func main() {
-	s, _ := server.NewServer()
+	// DEV_DIR runs the server locally: Firestore data is kept in memory and bucket objects are
+	// files under the directory, so nothing deployed is read or overwritten
+	if dir := os.Getenv("DEV_DIR"); dir != "" {
+		database.UseMemory()
+		gcs.UseDir(dir)
+		fmt.Printf("dev mode: data is kept in memory and bucket objects in %s\n", dir)
+	} else if os.Getenv("GRAPH_BASE_URL") != "" {
+		// Tokens and posts from a fake Graph API would overwrite the real ones
+		fmt.Printf("GRAPH_BASE_URL is set without DEV_DIR, refusing to use the %s project\n", util.Project)
+		os.Exit(1)
+	}
+
+	s, err := server.NewServer()
+	if err != nil {
+		fmt.Println("error starting server:", err)
+		os.Exit(1)
+	}
 	s.Run()
 }
@@ -17,7 +17,13 @@ func main() {
 		Weight:        115.0,
 	}
 
-	img, err := image.Generate(doc)
+	tmpl, err := image.LoadTemplate(image.PostTypeDaily)
+	if err != nil {
+		fmt.Println("error loading template:", err)
+		return
+	}
+
+	img, err := image.Generate(tmpl, image.Data{Document: doc, Streak: 12}, image.FormatJPEG)
 	if err != nil {
 		fmt.Println("error generating image:", err)
 	}
@@ -7,4 +7,5 @@ gcloud functions deploy weight-gen-image \
   --entry-point=GenerateProgressImage \
   --trigger-event-filters=type=google.cloud.firestore.document.v1.written \
   --trigger-event-filters=database='(default)' \
-  --trigger-event-filters-path-pattern=document='weightlog/{date}'
+  --trigger-event-filters-path-pattern=document='weightlog/{date}' \
+  --retry
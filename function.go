@@ -15,6 +15,17 @@ import (
 	"github.com/baely/weightloss-tracker/internal/util/image"
 )
 
+// init fails the function's startup rather than render with a broken template
+func init() {
+	settings, err := database.GetSettings()
+	if err != nil {
+		panic(fmt.Sprintf("error getting settings: %v", err))
+	}
+	if err = image.ValidateTemplates(settings); err != nil {
+		panic(err)
+	}
+}
+
 func EventDocumentToDocument(eventDoc *firestoredata.Document) database.Document {
 	fields := eventDoc.GetFields()
 
@@ -26,6 +37,10 @@ func EventDocumentToDocument(eventDoc *firestoredata.Document) database.Document
 		Weight:        fields["Weight"].GetDoubleValue(),
 	}
 
+	for _, v := range fields["Outliers"].GetArrayValue().GetValues() {
+		doc.Outliers = append(doc.Outliers, v.GetStringValue())
+	}
+
 	return doc
 }
 
@@ -42,19 +57,50 @@ func GenerateProgressImage(ctx context.Context, event event.Event) error {
 
 	doc := EventDocumentToDocument(value)
 
-	img, err := image.Generate(doc)
+	// Without the settings the card would ignore the privacy mode, and without the documents its
+	// progress would be wrong, so fail and let the event be retried rather than render it
+	settings, err := database.GetSettings()
 	if err != nil {
-		fmt.Println("error gen image:", err)
+		fmt.Println("error getting settings:", err)
 		return err
 	}
-	b := bytes.NewReader(img)
 
-	filename := fmt.Sprintf(image.FilenameFormat, doc.Title)
-	err = gcs.UploadFile(util.ResourceBucket, filename, b)
+	docs, err := database.GetAllDocuments()
 	if err != nil {
-		fmt.Println("error saving doc:", err)
+		fmt.Println("error getting documents:", err)
 		return err
 	}
 
+	card := image.NewData(doc, docs, settings)
+
+	tmpl, err := image.TemplateFor(settings, image.PostTypeDaily)
+	if err != nil {
+		fmt.Println("error loading template:", err)
+		return err
+	}
+
+	for _, size := range image.Sizes {
+		for _, format := range image.OutputFormats(settings) {
+			variant, err := image.VariantFor(tmpl, size)
+			if err != nil {
+				fmt.Println("error loading template:", err)
+				return err
+			}
+			img, err := image.Generate(variant, card, format)
+			if err != nil {
+				fmt.Println("error gen image:", err)
+				return err
+			}
+			b := bytes.NewReader(img)
+
+			filename := image.Filename(doc.Title, size, format)
+			err = gcs.UploadFile(util.ResourceBucket, filename, b)
+			if err != nil {
+				fmt.Println("error saving doc:", err)
+				return err
+			}
+		}
+	}
+
 	return nil
 }
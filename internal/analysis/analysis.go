@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"sort"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	dateFormat = "2006-01-02"
+)
+
+// Report is the result of an analysis pass over the document series
+type Report struct {
+	// Outliers maps a document title to the metrics flagged on that day
+	Outliers map[string][]string
+	Trend    []TrendPoint
+	TDEE     float64
+	Plateau  *Plateau
+}
+
+// Analyse runs outlier detection over the documents, then computes the weight trend,
+// TDEE and current plateau with the outliers excluded
+func Analyse(docs []database.Document) Report {
+	docs = sortDocuments(docs)
+
+	outliers := DetectOutliers(docs)
+	docs = flagDocuments(docs, outliers)
+
+	trend := Trend(docs)
+
+	return Report{
+		Outliers: outliers,
+		Trend:    trend,
+		TDEE:     TDEE(docs, trend, tdeeWindow),
+		Plateau:  DetectPlateau(trend),
+	}
+}
+
+// Changed returns the documents whose stored outlier flags differ from the report
+func (r Report) Changed(docs []database.Document) []database.Document {
+	changed := make([]database.Document, 0)
+	for _, doc := range docs {
+		flags := r.Outliers[doc.Title]
+		if equalFlags(doc.Outliers, flags) {
+			continue
+		}
+		if flags == nil {
+			flags = []string{}
+		}
+		doc.Outliers = flags
+		changed = append(changed, doc)
+	}
+	return changed
+}
+
+// sortDocuments returns a copy of the documents ordered by date
+func sortDocuments(docs []database.Document) []database.Document {
+	sorted := make([]database.Document, len(docs))
+	copy(sorted, docs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Title < sorted[j].Title
+	})
+	return sorted
+}
+
+// flagDocuments returns a copy of the documents with the detected outliers applied
+func flagDocuments(docs []database.Document, outliers map[string][]string) []database.Document {
+	flagged := make([]database.Document, len(docs))
+	for i, doc := range docs {
+		doc.Outliers = outliers[doc.Title]
+		flagged[i] = doc
+	}
+	return flagged
+}
+
+func equalFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isOutlier(doc database.Document, metric string) bool {
+	for _, o := range doc.Outliers {
+		if o == metric {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDate(title string) (time.Time, bool) {
+	t, err := time.Parse(dateFormat, title)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func daysBetween(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
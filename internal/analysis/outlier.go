@@ -0,0 +1,103 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// outlierWindow is the number of days either side of a reading that are used as its neighbourhood
+	outlierWindow = 7
+	// outlierMinNeighbours is the fewest neighbouring readings needed before a reading can be judged
+	outlierMinNeighbours = 4
+	// outlierThreshold is how many robust standard deviations a reading may sit from its neighbourhood median
+	outlierThreshold = 4.0
+)
+
+// Metric describes a document field that is checked for outliers
+type Metric struct {
+	Name  string
+	Value func(database.Document) float64
+	// MinSpread is the smallest spread assumed for the metric, so a run of identical readings doesn't
+	// flag ordinary day-to-day noise
+	MinSpread float64
+}
+
+var Metrics = []Metric{
+	{Name: "Weight", Value: func(d database.Document) float64 { return d.Weight }, MinSpread: 0.5},
+	{Name: "IntakeEnergy", Value: func(d database.Document) float64 { return d.IntakeEnergy }, MinSpread: 800},
+	{Name: "ActiveEnergy", Value: func(d database.Document) float64 { return d.ActiveEnergy }, MinSpread: 400},
+	{Name: "RestingEnergy", Value: func(d database.Document) float64 { return d.RestingEnergy }, MinSpread: 300},
+}
+
+type reading struct {
+	title string
+	date  time.Time
+	value float64
+}
+
+// DetectOutliers compares each reading with the median of the readings around it and flags any
+// that sit too many median absolute deviations away. Documents must be sorted by date.
+func DetectOutliers(docs []database.Document) map[string][]string {
+	outliers := make(map[string][]string)
+
+	for _, metric := range Metrics {
+		readings := make([]reading, 0, len(docs))
+		for _, doc := range docs {
+			v := metric.Value(doc)
+			date, ok := parseDate(doc.Title)
+			if v == 0 || !ok {
+				continue
+			}
+			readings = append(readings, reading{title: doc.Title, date: date, value: v})
+		}
+
+		for i, r := range readings {
+			neighbours := make([]float64, 0, 2*outlierWindow)
+			for j, n := range readings {
+				if i == j {
+					continue
+				}
+				d := daysBetween(r.date, n.date)
+				if d < -outlierWindow || d > outlierWindow {
+					continue
+				}
+				neighbours = append(neighbours, n.value)
+			}
+			if len(neighbours) < outlierMinNeighbours {
+				continue
+			}
+
+			m := median(neighbours)
+			deviations := make([]float64, len(neighbours))
+			for k, n := range neighbours {
+				deviations[k] = math.Abs(n - m)
+			}
+			spread := math.Max(1.4826*median(deviations), metric.MinSpread)
+
+			if math.Abs(r.value-m) > outlierThreshold*spread {
+				outliers[r.title] = append(outliers[r.title], metric.Name)
+			}
+		}
+	}
+
+	return outliers
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,158 @@
+package analysis
+
+import (
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// adherenceWindow is the number of days, up to the as-of date, used for the adherence rate
+	adherenceWindow = 30
+)
+
+// StreakRule configures how streaks and adherence are counted
+type StreakRule struct {
+	// GraceDays is how many consecutive missed days a streak survives. Missed days never add to
+	// the streak length.
+	GraceDays int
+	// IntakeTarget is the daily intake target in kJ, 0 disables adherence tracking
+	IntakeTarget float64
+}
+
+// RuleFromSettings builds the streak rule from the user's settings
+func RuleFromSettings(settings database.Settings) StreakRule {
+	return StreakRule{
+		GraceDays:    settings.StreakGraceDays,
+		IntakeTarget: settings.IntakeTarget,
+	}
+}
+
+// Streak is a run of consecutive logged days
+type Streak struct {
+	Current      int
+	Longest      int
+	LongestStart string
+	LongestEnd   string
+}
+
+// Adherence is how often logged intake stayed at or under target
+type Adherence struct {
+	Target   float64
+	Days     int
+	OnTarget int
+	Rate     float64
+}
+
+// Streaks holds the complete-day streak, a streak per metric and intake adherence
+type Streaks struct {
+	// Complete counts days with both a weigh-in and a food log
+	Complete  Streak
+	Metrics   map[string]Streak
+	Adherence Adherence
+}
+
+// ComputeStreaks counts logging streaks up to and including asOf, a date in "2006-01-02" form.
+// A missing asOf day doesn't break a streak as the day may still be in progress.
+func ComputeStreaks(docs []database.Document, asOf string, rule StreakRule) Streaks {
+	docs = sortDocuments(docs)
+
+	end, ok := parseDate(asOf)
+	if !ok {
+		return Streaks{Metrics: map[string]Streak{}}
+	}
+
+	byDate := make(map[string]database.Document)
+	for _, doc := range docs {
+		if doc.Title <= asOf {
+			byDate[doc.Title] = doc
+		}
+	}
+
+	var start time.Time
+	if len(docs) > 0 {
+		start, _ = parseDate(docs[0].Title)
+	}
+
+	streaks := Streaks{
+		Complete: countStreak(byDate, start, end, rule.GraceDays, func(d database.Document) bool {
+			return d.Weight != 0 && d.IntakeEnergy != 0
+		}),
+		Metrics:   make(map[string]Streak),
+		Adherence: adherence(byDate, end, rule.IntakeTarget),
+	}
+
+	for _, metric := range Metrics {
+		value := metric.Value
+		streaks.Metrics[metric.Name] = countStreak(byDate, start, end, rule.GraceDays, func(d database.Document) bool {
+			return value(d) != 0
+		})
+	}
+
+	return streaks
+}
+
+func countStreak(byDate map[string]database.Document, start, end time.Time, grace int, logged func(database.Document) bool) Streak {
+	var s Streak
+	var current, missed int
+	var currentStart string
+
+	if start.IsZero() {
+		return s
+	}
+
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		title := day.Format(dateFormat)
+		doc, ok := byDate[title]
+
+		if !ok || !logged(doc) {
+			if day.Equal(end) {
+				break
+			}
+			missed++
+			if missed > grace {
+				current = 0
+			}
+			continue
+		}
+
+		if current == 0 {
+			currentStart = title
+		}
+		current++
+		missed = 0
+
+		if current > s.Longest {
+			s.Longest = current
+			s.LongestStart = currentStart
+			s.LongestEnd = title
+		}
+	}
+
+	s.Current = current
+	return s
+}
+
+func adherence(byDate map[string]database.Document, end time.Time, target float64) Adherence {
+	a := Adherence{Target: target}
+	if target == 0 {
+		return a
+	}
+
+	for i := 0; i < adherenceWindow; i++ {
+		doc, ok := byDate[end.AddDate(0, 0, -i).Format(dateFormat)]
+		if !ok || doc.IntakeEnergy == 0 {
+			continue
+		}
+		a.Days++
+		if doc.IntakeEnergy <= target {
+			a.OnTarget++
+		}
+	}
+
+	if a.Days > 0 {
+		a.Rate = float64(a.OnTarget) / float64(a.Days)
+	}
+
+	return a
+}
@@ -0,0 +1,223 @@
+package analysis
+
+import (
+	"math"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// trendSmoothing is the weight given to each new reading in the exponentially smoothed trend
+	trendSmoothing = 0.1
+	// energyPerKg is the approximate energy content of a kilogram of body mass, in kJ
+	energyPerKg = 32_200
+	// tdeeWindow is the number of days used to estimate TDEE
+	tdeeWindow = 14
+	// plateauMinDays is how long the trend must stay flat before it is reported as a plateau
+	plateauMinDays = 21
+	// plateauTolerance is the most the trend may move, in kg, while still counting as flat
+	plateauTolerance = 0.3
+)
+
+// TrendPoint is a day's raw weight alongside its smoothed trend weight
+type TrendPoint struct {
+	Date   string
+	Weight float64
+	Trend  float64
+}
+
+// Plateau describes a stretch of days over which the trend weight barely moved
+type Plateau struct {
+	Start  string
+	End    string
+	Days   int
+	Change float64
+}
+
+// Trend computes an exponentially smoothed moving average of weight. Days without a weigh-in, or
+// with an outlier weight, carry the previous trend forward. Documents must be sorted by date.
+func Trend(docs []database.Document) []TrendPoint {
+	points := make([]TrendPoint, 0, len(docs))
+
+	var trend float64
+	for _, doc := range docs {
+		weight := doc.Weight
+		if isOutlier(doc, "Weight") {
+			weight = 0
+		}
+
+		if weight != 0 {
+			if trend == 0 {
+				trend = weight
+			} else {
+				trend += trendSmoothing * (weight - trend)
+			}
+		}
+
+		if trend == 0 {
+			continue
+		}
+
+		points = append(points, TrendPoint{Date: doc.Title, Weight: weight, Trend: trend})
+	}
+
+	return points
+}
+
+// TDEE estimates total daily energy expenditure in kJ over the last window days from average
+// intake and the change in trend weight. Outlier intakes are excluded. It returns 0 when there
+// isn't enough data for an estimate.
+func TDEE(docs []database.Document, trend []TrendPoint, window int) float64 {
+	if len(docs) == 0 || len(trend) == 0 {
+		return 0
+	}
+
+	end, ok := parseDate(docs[len(docs)-1].Title)
+	if !ok {
+		return 0
+	}
+
+	var intake float64
+	var intakeDays int
+	for _, doc := range docs {
+		date, ok := parseDate(doc.Title)
+		if !ok || daysBetween(date, end) >= window {
+			continue
+		}
+		if doc.IntakeEnergy == 0 || isOutlier(doc, "IntakeEnergy") {
+			continue
+		}
+		intake += doc.IntakeEnergy
+		intakeDays++
+	}
+
+	var first, last *TrendPoint
+	for i := range trend {
+		date, ok := parseDate(trend[i].Date)
+		if !ok || daysBetween(date, end) >= window {
+			continue
+		}
+		if first == nil {
+			first = &trend[i]
+		}
+		last = &trend[i]
+	}
+
+	if first == nil || intakeDays < window/2 {
+		return 0
+	}
+
+	start, _ := parseDate(first.Date)
+	finish, _ := parseDate(last.Date)
+	days := daysBetween(start, finish)
+	if days < window/2 {
+		return 0
+	}
+
+	return intake/float64(intakeDays) - (last.Trend-first.Trend)*energyPerKg/float64(days)
+}
+
+// DetectPlateau reports whether the trend has stayed within plateauTolerance for at least
+// plateauMinDays up to the latest point. It returns nil when the trend is still moving.
+func DetectPlateau(trend []TrendPoint) *Plateau {
+	if len(trend) == 0 {
+		return nil
+	}
+
+	last := trend[len(trend)-1]
+	end, ok := parseDate(last.Date)
+	if !ok {
+		return nil
+	}
+
+	low, high := last.Trend, last.Trend
+	start := len(trend) - 1
+	for i := len(trend) - 2; i >= 0; i-- {
+		l, h := math.Min(low, trend[i].Trend), math.Max(high, trend[i].Trend)
+		if h-l > plateauTolerance {
+			break
+		}
+		low, high = l, h
+		start = i
+	}
+
+	begin, ok := parseDate(trend[start].Date)
+	if !ok {
+		return nil
+	}
+
+	days := daysBetween(begin, end)
+	if days < plateauMinDays {
+		return nil
+	}
+
+	return &Plateau{
+		Start:  trend[start].Date,
+		End:    last.Date,
+		Days:   days,
+		Change: last.Trend - trend[start].Trend,
+	}
+}
+
+// Progress is weight progress expressed relative to the start of the series
+type Progress struct {
+	StartWeight float64
+	// Change is the weight change in kg since the start
+	Change float64
+	// Percent is the weight change as a percentage of the start weight
+	Percent float64
+	// TrendDelta is the change in trend weight over the last 7 days
+	TrendDelta float64
+}
+
+// ComputeProgress measures progress up to and including asOf, a date in "2006-01-02" form. The
+// day's own weigh-in is used if there is one, otherwise its trend weight.
+func ComputeProgress(docs []database.Document, asOf string) Progress {
+	docs = sortDocuments(docs)
+	docs = flagDocuments(docs, DetectOutliers(docs))
+
+	upTo := make([]database.Document, 0, len(docs))
+	for _, doc := range docs {
+		if doc.Title <= asOf {
+			upTo = append(upTo, doc)
+		}
+	}
+
+	trend := Trend(upTo)
+	if len(trend) == 0 {
+		return Progress{}
+	}
+
+	var p Progress
+	for _, point := range trend {
+		if point.Weight != 0 {
+			p.StartWeight = point.Weight
+			break
+		}
+	}
+
+	last := trend[len(trend)-1]
+	current := last.Trend
+	if last.Date == asOf && last.Weight != 0 {
+		current = last.Weight
+	}
+
+	p.Change = current - p.StartWeight
+	if p.StartWeight != 0 {
+		p.Percent = 100 * p.Change / p.StartWeight
+	}
+
+	end, ok := parseDate(asOf)
+	if !ok {
+		return p
+	}
+	weekAgo := end.AddDate(0, 0, -7).Format(dateFormat)
+	for i := len(trend) - 1; i >= 0; i-- {
+		if trend[i].Date <= weekAgo {
+			p.TrendDelta = last.Trend - trend[i].Trend
+			break
+		}
+	}
+
+	return p
+}
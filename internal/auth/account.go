@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+)
+
+// Account is the Instagram business account posted to, with the page token to post with
+type Account struct {
+	IgId      string
+	PageToken string
+}
+
+// GetAccount returns the account cached with the token, finding and caching it if it isn't cached.
+// It reports whether the account came from the cache.
+func GetAccount() (Account, bool, error) {
+	t, err := database.GetToken()
+	if err != nil {
+		return Account{}, false, fmt.Errorf("error getting long token: %w", err)
+	}
+
+	if t.IgId != "" && t.PageToken != "" {
+		return Account{IgId: t.IgId, PageToken: t.PageToken}, true, nil
+	}
+
+	err = discover(&t)
+	if err != nil {
+		return Account{}, false, err
+	}
+
+	err = t.InsertOrUpdate()
+	if err != nil {
+		fmt.Println("error caching account:", err)
+	}
+
+	return Account{IgId: t.IgId, PageToken: t.PageToken}, false, nil
+}
+
+// ForgetAccount clears the cached account, so the next post finds it again
+func ForgetAccount() {
+	t, err := database.GetToken()
+	if err != nil {
+		fmt.Println("error getting token:", err)
+		return
+	}
+
+	forget(&t)
+	if err = t.InsertOrUpdate(); err != nil {
+		fmt.Println("error clearing cached account:", err)
+	}
+}
+
+// discover finds the page and business account for the token
+func discover(t *database.TokenDocument) error {
+	if t.UserId == "" {
+		userId, err := meta.Retry(func() (string, error) {
+			return meta.GetUserId(t.Token)
+		})
+		if err != nil {
+			return fmt.Errorf("error getting user: %w", err)
+		}
+		t.UserId = userId
+	}
+
+	type page struct{ id, token string }
+	p, err := meta.Retry(func() (page, error) {
+		id, token, err := meta.GetPage(t.UserId, t.Token)
+		return page{id, token}, err
+	})
+	if err != nil {
+		return fmt.Errorf("error getting page: %w", err)
+	}
+
+	igId, err := meta.Retry(func() (string, error) {
+		return meta.BusinessAccount(p.id, p.token)
+	})
+	if err != nil {
+		return fmt.Errorf("error getting business account: %w", err)
+	}
+
+	t.PageId, t.PageToken, t.IgId = p.id, p.token, igId
+	return nil
+}
+
+func forget(t *database.TokenDocument) {
+	t.PageId, t.PageToken, t.IgId = "", "", ""
+}
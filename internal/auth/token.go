@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+	"github.com/baely/weightloss-tracker/internal/integrations/ntfy"
+)
+
+const (
+	// refreshBefore is how close to expiry the long token is refreshed
+	refreshBefore = 14 * 24 * time.Hour
+	// warnBefore is how close to expiry a notification goes out if the token couldn't be
+	// refreshed, leaving time to reauthorise
+	warnBefore = 7 * 24 * time.Hour
+
+	// tokenNotice names the record of the last notification about the token
+	tokenNotice = "token"
+	// tokenReminder is how often a notification about the same problem with the same token is
+	// repeated
+	tokenReminder = 3 * 24 * time.Hour
+)
+
+// Status is the state of the stored token, without the token itself
+type Status struct {
+	Valid     bool      `json:"valid"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	CheckedAt time.Time `json:"checkedAt"`
+	// Refreshed reports whether the token was replaced during the check
+	Refreshed bool `json:"refreshed"`
+}
+
+func statusOf(t database.TokenDocument, refreshed bool) Status {
+	return Status{
+		Valid:     t.Valid,
+		IssuedAt:  t.IssuedAt,
+		ExpiresAt: t.ExpiresAt,
+		Scopes:    t.Scopes,
+		CheckedAt: t.CheckedAt,
+		Refreshed: refreshed,
+	}
+}
+
+// Store saves a new long token, then inspects it and saves it again with its lifetime. The
+// token is saved first so a failure inspecting it or finding the account doesn't lose it; the
+// next check fills in whatever is missing.
+func Store(long meta.LongToken) (Status, error) {
+	t := database.TokenDocument{Token: long.AccessToken, Valid: true, CheckedAt: time.Now()}
+	if long.ExpiresIn > 0 {
+		t.ExpiresAt = t.CheckedAt.Add(time.Duration(long.ExpiresIn) * time.Second)
+	}
+	err := t.InsertOrUpdate()
+	if err != nil {
+		return Status{}, fmt.Errorf("error saving token: %v", err)
+	}
+
+	inspected, err := inspect(long.AccessToken)
+	if err != nil {
+		fmt.Println("error inspecting stored token:", err)
+		return statusOf(t, false), nil
+	}
+	// debug_token doesn't always report an expiry for user tokens, so fall back to the lifetime
+	// the exchange returned
+	if inspected.ExpiresAt.IsZero() {
+		inspected.ExpiresAt = t.ExpiresAt
+	}
+	t = inspected
+
+	// Posting finds the account itself if this fails
+	if t.Valid {
+		if err = discover(&t); err != nil {
+			fmt.Println("error finding account:", err)
+		}
+	}
+
+	err = t.InsertOrUpdate()
+	if err != nil {
+		fmt.Println("error saving inspected token:", err)
+	}
+
+	return statusOf(t, false), nil
+}
+
+// Check inspects the stored token and refreshes it once it is within refreshBefore of expiring,
+// or straight away when forced. A notification with the reauthorisation link goes out when the
+// token is invalid, or is close to expiring and couldn't be refreshed.
+func Check(force bool) (Status, error) {
+	stored, err := database.GetToken()
+	if err != nil {
+		return Status{}, fmt.Errorf("error getting token: %v", err)
+	}
+
+	t, err := inspect(stored.Token)
+	if err != nil {
+		return Status{}, err
+	}
+	if t.ExpiresAt.IsZero() {
+		// Keep the expiry from the exchange when debug_token doesn't report one
+		t.ExpiresAt = stored.ExpiresAt
+	}
+	t.PageId, t.PageToken, t.IgId = stored.PageId, stored.PageToken, stored.IgId
+
+	if !t.Valid {
+		forget(&t)
+		err = t.InsertOrUpdate()
+		if err != nil {
+			return Status{}, fmt.Errorf("error saving token: %v", err)
+		}
+		notifyInvalid(t, "debug_token reports the token is no longer valid")
+		return statusOf(t, false), nil
+	}
+
+	if force || expiresWithin(t, refreshBefore) {
+		long, err := meta.GetLongToken(t.Token)
+		if err == nil {
+			status, err := Store(long)
+			if err == nil {
+				return status, nil
+			}
+			fmt.Println("error storing refreshed token:", err)
+		} else {
+			fmt.Println("error refreshing token:", err)
+		}
+	}
+
+	err = t.InsertOrUpdate()
+	if err != nil {
+		return Status{}, fmt.Errorf("error saving token: %v", err)
+	}
+
+	if expiresWithin(t, warnBefore) {
+		days := int(time.Until(t.ExpiresAt).Hours() / 24)
+		notify(t, "expiring", fmt.Sprintf("Instagram token expires in %d days and couldn't be refreshed, reauthorise at %s", days, meta.AuthUrl()))
+	}
+
+	return statusOf(t, false), nil
+}
+
+// Invalidate marks the stored token invalid after the Graph API rejected it, clears the cached
+// account, and sends a notification with the reauthorisation link
+func Invalidate(reason error) {
+	t, err := database.GetToken()
+	if err != nil {
+		fmt.Println("error getting token:", err)
+	} else {
+		t.Valid = false
+		t.CheckedAt = time.Now()
+		forget(&t)
+		if err = t.InsertOrUpdate(); err != nil {
+			fmt.Println("error saving token:", err)
+		}
+	}
+
+	notifyInvalid(t, reason.Error())
+}
+
+func notifyInvalid(t database.TokenDocument, reason string) {
+	notify(t, "invalid", fmt.Sprintf("Instagram token is invalid, reauthorise at %s\n%s", meta.AuthUrl(), reason))
+}
+
+// notify sends a notification about a problem with the token at most once per tokenReminder for
+// each problem and token, so daily checks and rejected posts don't repeat it. Tokens are told
+// apart by when they were issued. A notice that can't be read is sent anyway.
+func notify(t database.TokenDocument, problem, message string) {
+	key := fmt.Sprintf("%s %d", problem, t.IssuedAt.Unix())
+
+	notice, err := database.GetNotice(tokenNotice)
+	if err != nil {
+		fmt.Println("error getting token notice:", err)
+		notice = database.NoticeDocument{Name: tokenNotice}
+	} else if !notice.Due(key, tokenReminder) {
+		return
+	}
+
+	err = ntfy.Notify(message)
+	if err != nil {
+		fmt.Println("error sending token notice:", err)
+		return
+	}
+
+	notice.Key, notice.SentAt = key, time.Now()
+	err = notice.InsertOrUpdate()
+	if err != nil {
+		fmt.Println("error saving token notice:", err)
+	}
+}
+
+// inspect builds the token document from what debug_token reports about a token
+func inspect(token string) (database.TokenDocument, error) {
+	info, err := meta.InspectToken(token)
+	if err != nil {
+		return database.TokenDocument{}, fmt.Errorf("error inspecting token: %v", err)
+	}
+
+	t := database.TokenDocument{
+		Token:     token,
+		UserId:    info.Data.UserId,
+		Scopes:    info.Data.Scopes,
+		Valid:     info.Data.IsValid,
+		CheckedAt: time.Now(),
+	}
+	if info.Data.IssuedAt > 0 {
+		t.IssuedAt = time.Unix(int64(info.Data.IssuedAt), 0)
+	}
+	if info.Data.ExpiresAt > 0 {
+		t.ExpiresAt = time.Unix(int64(info.Data.ExpiresAt), 0)
+	}
+
+	return t, nil
+}
+
+// expiresWithin reports whether the token expires within d. Tokens that don't expire never do.
+func expiresWithin(t database.TokenDocument, d time.Duration) bool {
+	return !t.ExpiresAt.IsZero() && time.Until(t.ExpiresAt) < d
+}
@@ -0,0 +1,210 @@
+package caption
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/integrations/gcs"
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const (
+	// configObject is the object name of the caption config in the static resource bucket
+	configObject = "captions.json"
+	// configTTL is how long a loaded config is used before it's fetched again
+	configTTL = 10 * time.Minute
+
+	defaultHashtags = "default"
+)
+
+//go:embed captions/default.json
+var builtinConfig []byte
+
+// Config declares the caption templates, hashtag sets and destination limits
+type Config struct {
+	// Templates maps a post kind, such as "daily", to a text/template rendered with Data
+	Templates map[string]string `json:"templates"`
+	// Hashtags maps a set name to its hashtags. Destinations use the "default" set unless they
+	// name another.
+	Hashtags map[string][]string `json:"hashtags"`
+	// Destinations maps a destination, such as "instagram", to its caption rules
+	Destinations map[string]Destination `json:"destinations"`
+}
+
+// Destination is how captions are fitted to a destination
+type Destination struct {
+	// Templates overrides the config's templates for this destination, by post kind
+	Templates map[string]string `json:"templates,omitempty"`
+	// Hashtags names the hashtag set appended to captions, "none" for no hashtags
+	Hashtags string `json:"hashtags,omitempty"`
+	// MaxLength is the most characters a caption can have, 0 for no limit
+	MaxLength int `json:"maxLength,omitempty"`
+	// MaxHashtags is the most hashtags appended, 0 for no limit
+	MaxHashtags int `json:"maxHashtags,omitempty"`
+	// Charset is the characters the destination accepts: "unicode", "bmp" to drop characters
+	// outside the basic multilingual plane such as most emoji, or "ascii"
+	Charset Charset `json:"charset,omitempty"`
+}
+
+var configCache = struct {
+	sync.Mutex
+	config *Config
+	loaded time.Time
+}{}
+
+var funcs = template.FuncMap{
+	"abs": math.Abs,
+	// kg formats a weight to one decimal place
+	"kg": func(v float64) string { return fmt.Sprintf("%.1f", v) },
+	// signed formats a change to one decimal place with its sign
+	"signed": func(v float64) string { return fmt.Sprintf("%+.1f", v) },
+	// kj formats energy to a whole number
+	"kj": func(v float64) string { return fmt.Sprintf("%.0f", v) },
+}
+
+// ParseConfig decodes and validates a JSON caption config
+func ParseConfig(b []byte) (*Config, error) {
+	var c Config
+	err := json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Validate checks that every template parses and every named hashtag set and charset exists
+func (c *Config) Validate() error {
+	for kind, text := range c.Templates {
+		if _, err := parse(text); err != nil {
+			return fmt.Errorf("template %q: %v", kind, err)
+		}
+	}
+
+	for name, d := range c.Destinations {
+		for kind, text := range d.Templates {
+			if _, err := parse(text); err != nil {
+				return fmt.Errorf("destination %q: template %q: %v", name, kind, err)
+			}
+		}
+		if _, ok := c.Hashtags[d.Hashtags]; d.Hashtags != "" && d.Hashtags != "none" && !ok {
+			return fmt.Errorf("destination %q: unknown hashtag set %q", name, d.Hashtags)
+		}
+		if !d.Charset.valid() {
+			return fmt.Errorf("destination %q: unknown charset %q", name, d.Charset)
+		}
+		if d.MaxLength < 0 || d.MaxHashtags < 0 {
+			return fmt.Errorf("destination %q: negative limit", name)
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig returns the caption config from the static resource bucket, falling back to the
+// one built into the binary when there isn't one
+func LoadConfig() (*Config, error) {
+	configCache.Lock()
+	defer configCache.Unlock()
+
+	if configCache.config != nil && time.Since(configCache.loaded) < configTTL {
+		return configCache.config, nil
+	}
+
+	c, err := fetchConfig()
+	if err != nil {
+		if configCache.config != nil {
+			fmt.Printf("error reloading caption config, using cached copy: %v\n", err)
+			return configCache.config, nil
+		}
+		return nil, err
+	}
+
+	configCache.config, configCache.loaded = c, time.Now()
+	return c, nil
+}
+
+func fetchConfig() (*Config, error) {
+	f, err := gcs.ReadFile(util.StaticResourceBucket, configObject)
+	if err != nil && !gcs.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		return ParseConfig(b)
+	}
+
+	return BuiltinConfig()
+}
+
+// BuiltinConfig returns the caption config built into the binary
+func BuiltinConfig() (*Config, error) {
+	return ParseConfig(builtinConfig)
+}
+
+// Render renders the caption of a kind of post, such as "daily", for each destination
+func (c *Config) Render(kind string, data Data, destinations []string) (map[string]string, error) {
+	captions := make(map[string]string, len(destinations))
+	for _, name := range destinations {
+		d := c.Destinations[name]
+
+		text, ok := d.Templates[kind]
+		if !ok {
+			text, ok = c.Templates[kind]
+		}
+		if !ok {
+			return nil, fmt.Errorf("no caption template for %q", kind)
+		}
+
+		t, err := parse(text)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err = t.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("error rendering %s caption for %s: %v", kind, name, err)
+		}
+
+		captions[name] = d.fit(buf.String(), c.hashtags(d))
+	}
+
+	return captions, nil
+}
+
+func (c *Config) hashtags(d Destination) []string {
+	switch d.Hashtags {
+	case "none":
+		return nil
+	case "":
+		return c.Hashtags[defaultHashtags]
+	}
+	return c.Hashtags[d.Hashtags]
+}
+
+func parse(text string) (*template.Template, error) {
+	return template.New("caption").Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+func shiftDate(date string, days int) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, days).Format("2006-01-02")
+}
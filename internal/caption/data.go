@@ -0,0 +1,119 @@
+package caption
+
+import (
+	"github.com/baely/weightloss-tracker/internal/analysis"
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/util/image"
+)
+
+// Data is what a caption template can show. Fields hidden by the privacy settings are zero.
+type Data struct {
+	// Date is the day posted, or the last day of a weekly post, in "2006-01-02" form
+	Date string
+	// Start is the first day of a weekly post, empty for daily posts
+	Start string
+	// Days is the number of days a post covers
+	Days int
+
+	Weight        float64
+	IntakeEnergy  float64
+	ActiveEnergy  float64
+	RestingEnergy float64
+	IntakeTarget  float64
+
+	StartWeight   float64
+	WeightChange  float64
+	WeightPercent float64
+	TrendDelta    float64
+
+	Streak        int
+	LongestStreak int
+
+	GoalWeight float64
+	// ToGoal is the kg still to lose, or gain, to reach the goal weight
+	ToGoal float64
+	// GoalPercent is how far from the start weight to the goal weight the weight has come
+	GoalPercent float64
+}
+
+// NewData builds the caption data for a post covering the days up to and including date, with
+// progress computed from the full document series
+func NewData(date string, days int, docs []database.Document, settings database.Settings) Data {
+	d := Data{Date: date, Days: days, IntakeTarget: settings.IntakeTarget, GoalWeight: settings.GoalWeight}
+	if days > 1 {
+		d.Start = shiftDate(date, -days+1)
+	}
+
+	for _, doc := range docs {
+		if doc.Title == date {
+			d.Weight = doc.Weight
+			d.IntakeEnergy = doc.IntakeEnergy
+			d.ActiveEnergy = doc.ActiveEnergy
+			d.RestingEnergy = doc.RestingEnergy
+		}
+	}
+
+	progress := analysis.ComputeProgress(docs, date)
+	d.StartWeight = progress.StartWeight
+	d.WeightChange = progress.Change
+	d.WeightPercent = progress.Percent
+	d.TrendDelta = progress.TrendDelta
+
+	streaks := analysis.ComputeStreaks(docs, date, analysis.RuleFromSettings(settings))
+	d.Streak = streaks.Complete.Current
+	d.LongestStreak = streaks.Complete.Longest
+
+	if d.GoalWeight != 0 && d.StartWeight != 0 {
+		current := d.StartWeight + d.WeightChange
+		d.ToGoal = current - d.GoalWeight
+		if total := d.StartWeight - d.GoalWeight; total != 0 {
+			d.GoalPercent = 100 * d.WeightChange / -total
+		}
+	}
+
+	return d.private(image.PrivacyFor(settings))
+}
+
+// private zeroes what the privacy settings keep off public posts. Relative modes keep every
+// absolute weight off, leaving the goal percentage and only the change the mode shows, as the
+// change and percentage together give away the weight.
+func (d Data) private(p image.Privacy) Data {
+	if p.Relative() {
+		d.Weight, d.StartWeight, d.GoalWeight, d.ToGoal = 0, 0, 0, 0
+
+		shown := p.RelativeField()
+		if shown != "WeightChange" {
+			d.WeightChange = 0
+		}
+		if shown != "WeightPercent" {
+			d.WeightPercent = 0
+		}
+		if shown != "TrendDelta" {
+			d.TrendDelta = 0
+		}
+	}
+
+	for _, field := range p.Hidden {
+		switch field {
+		case "Weight":
+			d.Weight, d.StartWeight, d.GoalWeight, d.ToGoal, d.GoalPercent = 0, 0, 0, 0, 0
+			d.WeightChange, d.WeightPercent, d.TrendDelta = 0, 0, 0
+		case "IntakeEnergy":
+			d.IntakeEnergy, d.IntakeTarget = 0, 0
+		case "ActiveEnergy":
+			d.ActiveEnergy = 0
+		case "RestingEnergy":
+			d.RestingEnergy = 0
+		case "Streak":
+			d.Streak, d.LongestStreak = 0, 0
+		case "WeightChange":
+			d.WeightChange = 0
+		case "WeightPercent":
+			d.WeightPercent = 0
+		case "TrendDelta":
+			d.TrendDelta = 0
+		}
+	}
+
+	return d
+}
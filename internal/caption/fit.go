@@ -0,0 +1,133 @@
+package caption
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Charset is the set of characters a destination accepts in captions
+type Charset string
+
+const (
+	CharsetUnicode Charset = "unicode"
+	// CharsetBMP drops characters outside the basic multilingual plane, which covers most emoji
+	CharsetBMP Charset = "bmp"
+	// CharsetASCII transliterates common punctuation and drops other non-ASCII characters
+	CharsetASCII Charset = "ascii"
+)
+
+// asciiReplacer transliterates characters likely in captions
+var asciiReplacer = strings.NewReplacer(
+	"–", "-", "—", "-", "−", "-",
+	"‘", "'", "’", "'", "“", "\"", "”", "\"",
+	"…", "...", "·", "-", "•", "-",
+	"°", " deg", "×", "x",
+	"\u00a0", " ",
+)
+
+func (c Charset) valid() bool {
+	return c == "" || c == CharsetUnicode || c == CharsetBMP || c == CharsetASCII
+}
+
+// apply removes the characters the charset doesn't accept
+func (c Charset) apply(s string) string {
+	switch c {
+	case CharsetBMP:
+		return strings.Map(func(r rune) rune {
+			if r > 0xFFFF || r == '\u200d' || r == '\ufe0f' {
+				return -1
+			}
+			return r
+		}, s)
+	case CharsetASCII:
+		return strings.Map(func(r rune) rune {
+			if r > unicode.MaxASCII {
+				return -1
+			}
+			return r
+		}, asciiReplacer.Replace(s))
+	}
+	return s
+}
+
+// ellipsis marks a trimmed caption
+func (c Charset) ellipsis() string {
+	if c == CharsetASCII {
+		return "..."
+	}
+	return "…"
+}
+
+// fit applies the charset, then appends as many hashtags as fit in the length limit, trimming
+// the caption itself only when it doesn't fit on its own
+func (d Destination) fit(caption string, hashtags []string) string {
+	caption = strings.TrimSpace(d.Charset.apply(caption))
+	caption = collapseSpaces(caption)
+	if d.MaxLength > 0 {
+		caption = trim(caption, d.MaxLength, d.Charset.ellipsis())
+	}
+
+	var tags []string
+	for _, tag := range hashtags {
+		if d.MaxHashtags > 0 && len(tags) == d.MaxHashtags {
+			break
+		}
+
+		tag = d.Charset.apply(normaliseHashtag(tag))
+		if tag == "#" {
+			continue
+		}
+
+		sep := " "
+		if len(tags) == 0 {
+			sep = "\n\n"
+		}
+		if d.MaxLength > 0 && Length(caption)+Length(strings.Join(tags, " "))+Length(sep)+Length(tag) > d.MaxLength {
+			break
+		}
+		tags = append(tags, tag)
+	}
+
+	if len(tags) == 0 {
+		return caption
+	}
+	return caption + "\n\n" + strings.Join(tags, " ")
+}
+
+// normaliseHashtag makes a hashtag a single word starting with #
+func normaliseHashtag(tag string) string {
+	tag = strings.Join(strings.Fields(tag), "")
+	return "#" + strings.TrimLeft(tag, "#")
+}
+
+// collapseSpaces removes runs of spaces left by blank template fields, keeping line breaks
+func collapseSpaces(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Length is the length of a caption as destinations count it, in characters
+func Length(s string) int {
+	return len([]rune(s))
+}
+
+// trim shortens s to at most max characters, cutting at a word where possible and marking the cut
+func trim(s string, max int, ellipsis string) string {
+	if Length(s) <= max {
+		return s
+	}
+
+	keep := max - Length(ellipsis)
+	if keep <= 0 {
+		return string([]rune(ellipsis)[:max])
+	}
+
+	r := []rune(s)[:keep]
+	if i := strings.LastIndexAny(string(r), " \n"); i > 0 {
+		r = []rune(string(r)[:i])
+	}
+	return strings.TrimRight(string(r), " \n.,;:") + ellipsis
+}
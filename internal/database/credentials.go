@@ -0,0 +1,100 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/baely/weightloss-tracker/internal/envelope"
+)
+
+// seal encrypts a credential for storage under label. Without a key provider the credential is
+// stored as is, and nil is returned.
+func seal(plaintext string, label string) (*envelope.Sealed, error) {
+	if plaintext == "" || !envelope.Configured() {
+		return nil, nil
+	}
+
+	s, err := envelope.Seal([]byte(plaintext), label)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting %s: %v", label, err)
+	}
+	return &s, nil
+}
+
+// open decrypts a credential sealed under label, or returns plaintext when it wasn't sealed
+func open(plaintext string, sealed *envelope.Sealed, label string) (string, error) {
+	if sealed == nil {
+		return plaintext, nil
+	}
+
+	b, err := envelope.Open(*sealed, label)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting %s: %v", label, err)
+	}
+	return string(b), nil
+}
+
+func tokenLabel(field string) string {
+	return tokenCollection + "/" + tokenDocument + "/" + field
+}
+
+// seal moves the tokens into their sealed fields when a key provider is configured
+func (d *TokenDocument) seal() error {
+	sealed, err := seal(d.Token, tokenLabel("Token"))
+	if err != nil {
+		return err
+	}
+	if sealed != nil {
+		d.Token, d.SealedToken = "", sealed
+	}
+
+	sealed, err = seal(d.PageToken, tokenLabel("PageToken"))
+	if err != nil {
+		return err
+	}
+	if sealed != nil {
+		d.PageToken, d.SealedPageToken = "", sealed
+	}
+
+	return nil
+}
+
+// open decrypts the sealed fields back into the tokens
+func (d *TokenDocument) open() error {
+	token, err := open(d.Token, d.SealedToken, tokenLabel("Token"))
+	if err != nil {
+		return err
+	}
+	d.Token, d.SealedToken = token, nil
+
+	pageToken, err := open(d.PageToken, d.SealedPageToken, tokenLabel("PageToken"))
+	if err != nil {
+		return err
+	}
+	d.PageToken, d.SealedPageToken = pageToken, nil
+
+	return nil
+}
+
+// RotateCredentials re-encrypts every stored credential with the current key provider and key,
+// including credentials stored before encryption was configured. It returns how many were
+// re-encrypted.
+func RotateCredentials() (int, error) {
+	if !envelope.Configured() {
+		return 0, fmt.Errorf("no key provider configured")
+	}
+
+	t, err := GetToken()
+	if err != nil {
+		return 0, fmt.Errorf("error reading token: %v", err)
+	}
+	if t.Token == "" {
+		return 0, nil
+	}
+
+	err = t.InsertOrUpdate()
+	if err != nil {
+		return 0, fmt.Errorf("error re-encrypting token: %v", err)
+	}
+
+	return 1, nil
+}
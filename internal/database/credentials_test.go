@@ -0,0 +1,120 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/baely/weightloss-tracker/internal/envelope"
+)
+
+// writeKeyFile writes a local key file with the current key and new random keys for each ID in
+// ids, keeping any key already in keys
+func writeKeyFile(t *testing.T, path string, keys map[string]string, current string, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			t.Fatal(err)
+		}
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+
+	b, err := json.Marshal(map[string]interface{}{"current": current, "keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedToken returns the token document as stored, still sealed
+func storedToken(t *testing.T) TokenDocument {
+	t.Helper()
+
+	v, ok := memory.get(tokenCollection, tokenDocument)
+	if !ok {
+		t.Fatal("no token stored")
+	}
+	return v.(TokenDocument)
+}
+
+func checkToken(t *testing.T, token, pageToken string) {
+	t.Helper()
+
+	d, err := GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Token != token || d.PageToken != pageToken {
+		t.Errorf("read tokens %q and %q, want %q and %q", d.Token, d.PageToken, token, pageToken)
+	}
+}
+
+// TestRotateCredentials reseals the token with a new current key, so it can still be read once
+// the old key is removed
+func TestRotateCredentials(t *testing.T) {
+	t.Cleanup(UseMemory())
+	path := filepath.Join(t.TempDir(), "keys.json")
+	t.Cleanup(envelope.UseKeyFile(path))
+
+	keys := make(map[string]string)
+	writeKeyFile(t, path, keys, "2023-06", "2023-06")
+	if err := (TokenDocument{Token: "user-token", PageToken: "page-token"}).InsertOrUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedToken(t)
+	if stored.Token != "" || stored.PageToken != "" || stored.SealedToken == nil || stored.SealedPageToken == nil {
+		t.Fatalf("stored %+v, want the tokens sealed", stored)
+	}
+
+	writeKeyFile(t, path, keys, "2024-01", "2024-01")
+	n, err := RotateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("rotated %d credentials, want 1", n)
+	}
+	stored = storedToken(t)
+	if stored.SealedToken.KeyId != "2024-01" || stored.SealedPageToken.KeyId != "2024-01" {
+		t.Errorf("sealed with keys %q and %q after rotating, want %q", stored.SealedToken.KeyId, stored.SealedPageToken.KeyId, "2024-01")
+	}
+
+	delete(keys, "2023-06")
+	writeKeyFile(t, path, keys, "2024-01")
+	checkToken(t, "user-token", "page-token")
+}
+
+// TestPlaintextToken reads a token stored before encryption was configured, and seals it when
+// rotating
+func TestPlaintextToken(t *testing.T) {
+	t.Cleanup(UseMemory())
+	t.Cleanup(envelope.UseKeyFile(""))
+
+	if err := (TokenDocument{Token: "user-token", PageToken: "page-token"}).InsertOrUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedToken(t); stored.Token != "user-token" || stored.SealedToken != nil {
+		t.Fatalf("stored %+v without a key provider, want the token as is", stored)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	t.Cleanup(envelope.UseKeyFile(path))
+	writeKeyFile(t, path, make(map[string]string), "2023-06", "2023-06")
+	checkToken(t, "user-token", "page-token")
+
+	if _, err := RotateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	stored := storedToken(t)
+	if stored.Token != "" || stored.SealedToken == nil {
+		t.Errorf("stored %+v after rotating, want the token sealed", stored)
+	}
+	checkToken(t, "user-token", "page-token")
+}
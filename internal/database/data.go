@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"reflect"
+	"time"
 
 	"firebase.google.com/go/v4"
 	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/baely/weightloss-tracker/internal/envelope"
 	"github.com/baely/weightloss-tracker/internal/util"
 )
 
@@ -19,16 +22,64 @@ type Document struct {
 	RestingEnergy float64
 	IntakeEnergy  float64
 	Weight        float64
+	// Outliers lists the metrics on this day flagged by analysis as likely bad readings
+	Outliers []string
 }
 
 type TokenDocument struct {
-	Token string
+	// Token is stored empty, with the token in SealedToken, when a key provider is configured
+	Token       string
+	SealedToken *envelope.Sealed
+	// IssuedAt, ExpiresAt, Scopes and Valid are as debug_token last reported them. ExpiresAt is
+	// zero for tokens that don't expire.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Scopes    []string
+	Valid     bool
+	// CheckedAt is when the token was last inspected
+	CheckedAt time.Time
+	// UserId, PageId, PageToken and IgId are the accounts found for the token, kept so posting
+	// doesn't look them up each time. PageToken is sealed like Token.
+	UserId          string
+	PageId          string
+	PageToken       string
+	SealedPageToken *envelope.Sealed
+	IgId            string
+}
+
+// Settings holds user preferences, edited directly in Firestore
+type Settings struct {
+	// IntakeTarget is the daily intake target in kJ used for adherence tracking
+	IntakeTarget float64
+	// StreakGraceDays is how many consecutive missed days a logging streak survives
+	StreakGraceDays int
+	// ShowStreak adds the current logging streak to the daily image
+	ShowStreak bool
+	// Templates maps a post type, such as "daily", to the name of the image template used for it
+	Templates map[string]string
+	// Formats maps a destination, such as "web", to the image format rendered for it
+	Formats map[string]string
+	// Privacy is how weight is shown publicly: "absolute", "change", "percent" or "trend"
+	Privacy string
+	// HiddenFields lists document fields left off public images entirely
+	HiddenFields []string
+	// Palette is the colour palette for images: "light", "dark", "auto" to follow the time of
+	// day, or the name of one of Palettes
+	Palette string
+	// Palettes are custom palettes, mapping colour roles such as "accent" to "#rrggbb" colours
+	Palettes map[string]map[string]string
+	// Locale is the language of image labels, dates and numbers, such as "en" or "de"
+	Locale string
+	// GoalWeight is the target weight in kg used for goal progress in captions, 0 when there isn't one
+	GoalWeight float64
 }
 
 const (
 	weightLogCollection = "weightlog"
 	tokenCollection     = "token"
 	tokenDocument       = "token"
+	settingsCollection  = "settings"
+	settingsDocument    = "settings"
 )
 
 func updateIfNotEqual(source *Document, ref Document) bool {
@@ -44,8 +95,11 @@ func updateIfNotEqual(source *Document, ref Document) bool {
 	if source.Weight == 0 {
 		source.Weight = ref.Weight
 	}
+	if source.Outliers == nil {
+		source.Outliers = ref.Outliers
+	}
 
-	if *source != ref {
+	if !reflect.DeepEqual(*source, ref) {
 		return false
 	}
 
@@ -53,6 +107,14 @@ func updateIfNotEqual(source *Document, ref Document) bool {
 }
 
 func (d Document) InsertOrUpdate() error {
+	if memory != nil {
+		if v, ok := memory.get(weightLogCollection, d.Title); ok && updateIfNotEqual(&d, v.(Document)) {
+			return nil
+		}
+		memory.set(weightLogCollection, d.Title, d)
+		return nil
+	}
+
 	ctx := context.Background()
 
 	conf := &firebase.Config{ProjectID: util.Project}
@@ -106,6 +168,10 @@ func InsertOrUpdateDocuments(documents []Document) {
 }
 
 func GetAllDocuments() ([]Document, error) {
+	if memory != nil {
+		return memory.documents(), nil
+	}
+
 	ctx := context.Background()
 
 	conf := &firebase.Config{ProjectID: util.Project}
@@ -148,6 +214,14 @@ func GetAllDocuments() ([]Document, error) {
 }
 
 func (d TokenDocument) InsertOrUpdate() error {
+	if memory != nil {
+		if err := d.seal(); err != nil {
+			return err
+		}
+		memory.set(tokenCollection, tokenDocument, d)
+		return nil
+	}
+
 	ctx := context.Background()
 
 	conf := &firebase.Config{ProjectID: util.Project}
@@ -162,6 +236,11 @@ func (d TokenDocument) InsertOrUpdate() error {
 	}
 	defer client.Close()
 
+	err = d.seal()
+	if err != nil {
+		return err
+	}
+
 	docRef := client.Collection(tokenCollection).Doc(tokenDocument)
 
 	_, err = docRef.Set(ctx, d)
@@ -173,6 +252,18 @@ func (d TokenDocument) InsertOrUpdate() error {
 }
 
 func GetToken() (TokenDocument, error) {
+	if memory != nil {
+		v, ok := memory.get(tokenCollection, tokenDocument)
+		if !ok {
+			return TokenDocument{}, status.Error(codes.NotFound, "no token stored")
+		}
+		t := v.(TokenDocument)
+		if err := t.open(); err != nil {
+			return TokenDocument{}, err
+		}
+		return t, nil
+	}
+
 	ctx := context.Background()
 
 	conf := &firebase.Config{ProjectID: util.Project}
@@ -199,5 +290,51 @@ func GetToken() (TokenDocument, error) {
 		return TokenDocument{}, err
 	}
 
+	err = t.open()
+	if err != nil {
+		return TokenDocument{}, err
+	}
+
 	return t, nil
 }
+
+// GetSettings returns the stored settings, or the zero value if none have been saved
+func GetSettings() (Settings, error) {
+	if memory != nil {
+		if v, ok := memory.get(settingsCollection, settingsDocument); ok {
+			return v.(Settings), nil
+		}
+		return Settings{}, nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return Settings{}, fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return Settings{}, fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	docRef := client.Collection(settingsCollection).Doc(settingsDocument)
+	docSnapshot, err := docRef.Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return Settings{}, nil
+		}
+		return Settings{}, err
+	}
+
+	var settings Settings
+	err = docSnapshot.DataTo(&settings)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	return settings, nil
+}
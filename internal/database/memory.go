@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memory holds every collection in place of Firestore while set by UseMemory
+var memory *memoryStore
+
+type memoryStore struct {
+	sync.Mutex
+	docs map[string]interface{}
+}
+
+// UseMemory keeps the weight log, settings, token, post log and notices in memory instead of
+// Firestore, starting empty, so code that records them can be exercised, or run locally, without
+// touching a Firestore project. The returned function goes back to Firestore.
+func UseMemory() func() {
+	memory = &memoryStore{docs: make(map[string]interface{})}
+	return func() {
+		memory = nil
+	}
+}
+
+func memoryKey(collection, id string) string {
+	return fmt.Sprintf("%s/%s", collection, id)
+}
+
+func (m *memoryStore) get(collection, id string) (interface{}, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	v, ok := m.docs[memoryKey(collection, id)]
+	return v, ok
+}
+
+func (m *memoryStore) set(collection, id string, v interface{}) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.docs[memoryKey(collection, id)] = v
+}
+
+// documents returns the weight log ordered by date, as Firestore orders it by document ID
+func (m *memoryStore) documents() []Document {
+	m.Lock()
+	defer m.Unlock()
+
+	docs := make([]Document, 0)
+	for _, v := range m.docs {
+		if d, ok := v.(Document); ok {
+			docs = append(docs, d)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Title < docs[j].Title
+	})
+	return docs
+}
+
+// published returns the posts published since a time, oldest first, as GetPublishedPosts does
+func (m *memoryStore) published(since time.Time) []PostDocument {
+	m.Lock()
+	defer m.Unlock()
+
+	posts := make([]PostDocument, 0)
+	for _, v := range m.docs {
+		if p, ok := v.(PostDocument); ok && !p.PublishedAt.Before(since) {
+			posts = append(posts, p)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		if !posts[i].PublishedAt.Equal(posts[j].PublishedAt) {
+			return posts[i].PublishedAt.Before(posts[j].PublishedAt)
+		}
+		return posts[i].id() < posts[j].id()
+	})
+	return posts
+}
+
+// posts returns the posts dated from and to, inclusive, oldest first, as GetPosts does
+func (m *memoryStore) posts(from, to string) []PostDocument {
+	m.Lock()
+	defer m.Unlock()
+
+	posts := make([]PostDocument, 0)
+	for _, v := range m.docs {
+		if p, ok := v.(PostDocument); ok && p.Date >= from && p.Date <= to {
+			posts = append(posts, p)
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].Date != posts[j].Date {
+			return posts[i].Date < posts[j].Date
+		}
+		return posts[i].id() < posts[j].id()
+	})
+	return posts
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"firebase.google.com/go/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const noticeCollection = "notices"
+
+// NoticeDocument records the last time a recurring notification was sent, so it can be repeated
+// on a schedule rather than on every run that sees the same condition
+type NoticeDocument struct {
+	Name string
+	// Key identifies what the notice was about, such as the token it warned of. A notice with a
+	// different key is news rather than a repeat.
+	Key    string
+	SentAt time.Time
+}
+
+func (n NoticeDocument) InsertOrUpdate() error {
+	if memory != nil {
+		memory.set(noticeCollection, n.Name, n)
+		return nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	_, err = client.Collection(noticeCollection).Doc(n.Name).Set(ctx, n)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetNotice returns the last notice sent with a name, or one with a zero SentAt if none has been
+func GetNotice(name string) (NoticeDocument, error) {
+	if memory != nil {
+		if v, ok := memory.get(noticeCollection, name); ok {
+			return v.(NoticeDocument), nil
+		}
+		return NoticeDocument{Name: name}, nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return NoticeDocument{}, fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return NoticeDocument{}, fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	docSnapshot, err := client.Collection(noticeCollection).Doc(name).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return NoticeDocument{Name: name}, nil
+		}
+		return NoticeDocument{}, err
+	}
+
+	var n NoticeDocument
+	err = docSnapshot.DataTo(&n)
+	if err != nil {
+		return NoticeDocument{}, err
+	}
+
+	return n, nil
+}
+
+// Due reports whether a notice about key should be sent: when none has been sent, the last one
+// was about something else, or the last one was sent more than every ago
+func (n NoticeDocument) Due(key string, every time.Duration) bool {
+	return n.SentAt.IsZero() || n.Key != key || time.Since(n.SentAt) >= every
+}
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const postCollection = "posts"
+
+const (
+	// PostPending is recorded just before publishing, so an overlapping retry doesn't publish too
+	PostPending   = "pending"
+	PostPublished = "published"
+	PostFailed    = "failed"
+)
+
+// PostDocument records publishing one kind of post for a date to one destination
+type PostDocument struct {
+	Date        string
+	Kind        string
+	Destination string
+	// ContainerId is the upload staged before publishing, on destinations that stage one
+	ContainerId string
+	MediaId     string
+	Status      string
+	Error       string
+	UpdatedAt   time.Time
+	// PublishedAt is when the post was published, zero until it is
+	PublishedAt time.Time
+	// Insights are engagement snapshots taken at intervals after publishing, oldest first
+	Insights []Insights
+}
+
+// Insights is a post's engagement some time after it was published
+type Insights struct {
+	// After names the interval after publishing the snapshot was taken for, such as "7d"
+	After    string
+	At       time.Time
+	Reach    int
+	Views    int
+	Likes    int
+	Comments int
+	Saves    int
+}
+
+// Engagement is the interactions with a post: likes, comments and saves
+func (i Insights) Engagement() int {
+	return i.Likes + i.Comments + i.Saves
+}
+
+// LatestInsights returns the most recent insights snapshot, and false when there isn't one
+func (p PostDocument) LatestInsights() (Insights, bool) {
+	if len(p.Insights) == 0 {
+		return Insights{}, false
+	}
+	return p.Insights[len(p.Insights)-1], true
+}
+
+func (p PostDocument) id() string {
+	return fmt.Sprintf("%s_%s_%s", p.Date, p.Kind, p.Destination)
+}
+
+func (p PostDocument) InsertOrUpdate() error {
+	if memory != nil {
+		memory.set(postCollection, p.id(), p)
+		return nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	_, err = client.Collection(postCollection).Doc(p.id()).Set(ctx, p)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPost returns the record of a post, and whether there is one
+func GetPost(date, kind, destination string) (PostDocument, bool, error) {
+	id := PostDocument{Date: date, Kind: kind, Destination: destination}.id()
+	if memory != nil {
+		v, ok := memory.get(postCollection, id)
+		if !ok {
+			return PostDocument{}, false, nil
+		}
+		return v.(PostDocument), true, nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return PostDocument{}, false, fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return PostDocument{}, false, fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	docSnapshot, err := client.Collection(postCollection).Doc(id).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return PostDocument{}, false, nil
+		}
+		return PostDocument{}, false, err
+	}
+
+	var p PostDocument
+	err = docSnapshot.DataTo(&p)
+	if err != nil {
+		return PostDocument{}, false, err
+	}
+
+	return p, true, nil
+}
+
+// GetPosts returns the records of posts dated from and to, inclusive, oldest first
+func GetPosts(from, to string) ([]PostDocument, error) {
+	if memory != nil {
+		return memory.posts(from, to), nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	iter := client.Collection(postCollection).
+		Where("Date", ">=", from).
+		Where("Date", "<=", to).
+		OrderBy("Date", firestore.Asc).
+		Documents(ctx)
+
+	posts := make([]PostDocument, 0)
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var p PostDocument
+		err = doc.DataTo(&p)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, p)
+	}
+
+	return posts, nil
+}
+
+// GetPublishedPosts returns the records of posts published since a time, oldest first. A carousel
+// logged under several dates has its records ordered by date.
+func GetPublishedPosts(since time.Time) ([]PostDocument, error) {
+	if memory != nil {
+		return memory.published(since), nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	iter := client.Collection(postCollection).
+		Where("PublishedAt", ">=", since).
+		OrderBy("PublishedAt", firestore.Asc).
+		OrderBy(firestore.DocumentID, firestore.Asc).
+		Documents(ctx)
+
+	posts := make([]PostDocument, 0)
+	for {
+		doc, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var p PostDocument
+		err = doc.DataTo(&p)
+		if err != nil {
+			return nil, err
+		}
+
+		posts = append(posts, p)
+	}
+
+	return posts, nil
+}
+
+// ClaimPost records p, normally as pending, unless the log already has the post published or
+// pending since less than stale ago, in which case it returns that record and false. The record is
+// read and written in one transaction, so of two overlapping claims only one succeeds. force
+// claims the post whatever the log has.
+func ClaimPost(p PostDocument, force bool, stale time.Duration) (PostDocument, bool, error) {
+	if memory != nil {
+		memory.Lock()
+		defer memory.Unlock()
+
+		key := memoryKey(postCollection, p.id())
+		existing, _ := memory.docs[key].(PostDocument)
+		if !force && existing.blocks(stale) {
+			return existing, false, nil
+		}
+		memory.docs[key] = p
+		return existing, true, nil
+	}
+
+	ctx := context.Background()
+
+	conf := &firebase.Config{ProjectID: util.Project}
+	app, err := firebase.NewApp(ctx, conf)
+	if err != nil {
+		return PostDocument{}, false, fmt.Errorf("failed to create a firebase app: %v\n", err)
+	}
+
+	client, err := app.Firestore(ctx)
+	if err != nil {
+		return PostDocument{}, false, fmt.Errorf("failed to create a database client: %v\n", err)
+	}
+	defer client.Close()
+
+	ref := client.Collection(postCollection).Doc(p.id())
+
+	var existing PostDocument
+	var claimed bool
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		// The function reruns when the transaction conflicts with another
+		existing, claimed = PostDocument{}, false
+
+		docSnapshot, err := tx.Get(ref)
+		switch {
+		case status.Code(err) == codes.NotFound:
+		case err != nil:
+			return err
+		default:
+			if err = docSnapshot.DataTo(&existing); err != nil {
+				return err
+			}
+			if !force && existing.blocks(stale) {
+				return nil
+			}
+		}
+
+		claimed = true
+		return tx.Set(ref, p)
+	})
+	if err != nil {
+		return PostDocument{}, false, err
+	}
+
+	return existing, claimed, nil
+}
+
+// blocks reports whether the record stops another attempt at the post: it is published, or
+// pending since less than stale ago
+func (p PostDocument) blocks(stale time.Duration) bool {
+	return p.Status == PostPublished || p.Status == PostPending && time.Since(p.UpdatedAt) < stale
+}
@@ -0,0 +1,159 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+const dekSize = 32
+
+// KeyProvider wraps and unwraps data keys with a key encryption key it holds
+type KeyProvider interface {
+	// Name identifies the provider in sealed values, such as "local"
+	Name() string
+	// Wrap encrypts a data key with the current key, returning the ID of the key used
+	Wrap(dek []byte) (wrapped []byte, keyId string, err error)
+	// Unwrap decrypts a data key wrapped with the given key
+	Unwrap(wrapped []byte, keyId string) ([]byte, error)
+}
+
+// Sealed is a value encrypted with its own data key, stored alongside the data key wrapped by a
+// key provider
+type Sealed struct {
+	Provider   string
+	KeyId      string
+	WrappedKey []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// KEY_PROVIDER picks the provider new values are sealed with when more than one is configured.
+// Every configured provider can open values, so values can be moved between providers by
+// rotating.
+var providerName = os.Getenv("KEY_PROVIDER")
+
+// UseKeyFile seals and opens values with the local key file at path alone, ignoring the
+// environment, or with no provider when path is empty. The returned function goes back to the
+// providers from the environment.
+func UseKeyFile(path string) func() {
+	oldKeyPath, oldKmsKey, oldProviderName := keyPath, kmsKey, providerName
+	keyPath, kmsKey, providerName = path, "", ""
+	return func() {
+		keyPath, kmsKey, providerName = oldKeyPath, oldKmsKey, oldProviderName
+	}
+}
+
+// providers returns the configured providers by name
+func providers() map[string]KeyProvider {
+	ps := make(map[string]KeyProvider)
+	if keyPath != "" {
+		ps[localProvider] = Local{Path: keyPath}
+	}
+	if kmsKey != "" {
+		ps[kmsProvider] = KMS{Key: kmsKey}
+	}
+	return ps
+}
+
+// Current returns the provider values are sealed with, and false when none is configured
+func Current() (KeyProvider, bool) {
+	ps := providers()
+	if p, ok := ps[providerName]; ok {
+		return p, true
+	}
+	if p, ok := ps[kmsProvider]; ok {
+		return p, true
+	}
+	p, ok := ps[localProvider]
+	return p, ok
+}
+
+// Configured reports whether there is a provider to seal values with
+func Configured() bool {
+	_, ok := Current()
+	return ok
+}
+
+// Seal encrypts plaintext with a new data key wrapped by the current provider. The label binds the
+// value to where it is stored, so it can't be opened if copied elsewhere.
+func Seal(plaintext []byte, label string) (Sealed, error) {
+	p, ok := Current()
+	if !ok {
+		return Sealed{}, fmt.Errorf("no key provider configured")
+	}
+
+	dek := make([]byte, dekSize)
+	if _, err := rand.Read(dek); err != nil {
+		return Sealed{}, err
+	}
+
+	nonce, ciphertext, err := encrypt(dek, plaintext, []byte(label))
+	if err != nil {
+		return Sealed{}, err
+	}
+
+	wrapped, keyId, err := p.Wrap(dek)
+	if err != nil {
+		return Sealed{}, fmt.Errorf("error wrapping data key with %s: %v", p.Name(), err)
+	}
+
+	return Sealed{
+		Provider:   p.Name(),
+		KeyId:      keyId,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts a sealed value with the provider that sealed it
+func Open(s Sealed, label string) ([]byte, error) {
+	p, ok := providers()[s.Provider]
+	if !ok {
+		return nil, fmt.Errorf("key provider %q isn't configured", s.Provider)
+	}
+
+	dek, err := p.Unwrap(s.WrappedKey, s.KeyId)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key with %s key %q: %v", s.Provider, s.KeyId, err)
+	}
+
+	return decrypt(dek, s.Nonce, s.Ciphertext, []byte(label))
+}
+
+func encrypt(key, plaintext, additional []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return nonce, gcm.Seal(nil, nonce, plaintext, additional), nil
+}
+
+func decrypt(key, nonce, ciphertext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d", len(nonce))
+	}
+	return gcm.Open(nil, nonce, ciphertext, additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,151 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// keyFile is a local key file written for a test
+type keyFile struct {
+	path string
+	keys map[string][]byte
+}
+
+// newKeyFile configures a local key file, in the test's temp directory, as the only provider
+func newKeyFile(t *testing.T) *keyFile {
+	t.Helper()
+
+	f := &keyFile{path: filepath.Join(t.TempDir(), "keys.json"), keys: make(map[string][]byte)}
+	t.Cleanup(UseKeyFile(f.path))
+	return f
+}
+
+// add adds a new random key and makes it current
+func (f *keyFile) add(t *testing.T, id string) {
+	t.Helper()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	f.keys[id] = key
+	f.write(t, id)
+}
+
+// remove drops a key no longer used, keeping the current key
+func (f *keyFile) remove(t *testing.T, id, current string) {
+	t.Helper()
+
+	delete(f.keys, id)
+	f.write(t, current)
+}
+
+func (f *keyFile) write(t *testing.T, current string) {
+	t.Helper()
+
+	k := keys{Current: current, Keys: make(map[string]string)}
+	for id, key := range f.keys {
+		k.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	b, err := json.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(f.path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	f := newKeyFile(t)
+	f.add(t, "2023-06")
+
+	plaintext := []byte("long-lived-token")
+	s, err := Seal(plaintext, "token/token/Token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Provider != localProvider || s.KeyId != "2023-06" {
+		t.Errorf("sealed with %s key %q, want %s key %q", s.Provider, s.KeyId, localProvider, "2023-06")
+	}
+	if bytes.Contains(s.Ciphertext, plaintext) || bytes.Contains(s.WrappedKey, f.keys["2023-06"]) {
+		t.Error("sealed value holds the plaintext or the key")
+	}
+
+	opened, err := Open(s, "token/token/Token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened %q, want %q", opened, plaintext)
+	}
+}
+
+// TestOpenLabel doesn't open a value under a label other than the one it was sealed with, such as
+// a token copied to another field
+func TestOpenLabel(t *testing.T) {
+	f := newKeyFile(t)
+	f.add(t, "2023-06")
+
+	s, err := Seal([]byte("long-lived-token"), "token/token/Token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(s, "token/token/PageToken"); err == nil {
+		t.Error("opened under another label")
+	}
+}
+
+// TestLocalRotation opens values sealed with an old key after a new key is made current, and
+// values resealed by rotating once the old key is removed
+func TestLocalRotation(t *testing.T) {
+	f := newKeyFile(t)
+	f.add(t, "2023-06")
+
+	old, err := Seal([]byte("long-lived-token"), "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.add(t, "2024-01")
+	opened, err := Open(old, "label")
+	if err != nil {
+		t.Fatalf("opening a value sealed with the old key: %v", err)
+	}
+
+	rotated, err := Seal(opened, "label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.KeyId != "2024-01" {
+		t.Errorf("rotated with key %q, want %q", rotated.KeyId, "2024-01")
+	}
+
+	f.remove(t, "2023-06", "2024-01")
+	opened, err = Open(rotated, "label")
+	if err != nil {
+		t.Fatalf("opening a rotated value: %v", err)
+	}
+	if string(opened) != "long-lived-token" {
+		t.Errorf("opened %q, want %q", opened, "long-lived-token")
+	}
+	if _, err = Open(old, "label"); err == nil {
+		t.Error("opened a value sealed with a removed key")
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	t.Cleanup(UseKeyFile(""))
+
+	if Configured() {
+		t.Fatal("configured without a key file")
+	}
+	if _, err := Seal([]byte("long-lived-token"), "label"); err == nil {
+		t.Error("sealed without a key provider")
+	}
+}
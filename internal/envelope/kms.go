@@ -0,0 +1,110 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	kmsProvider = "kms"
+	kmsBaseUri  = "https://cloudkms.googleapis.com/v1"
+	kmsScope    = "https://www.googleapis.com/auth/cloudkms"
+)
+
+// KMS_KEY is a Cloud KMS crypto key, in
+// "projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>" form
+var kmsKey = os.Getenv("KMS_KEY")
+
+// KMS wraps data keys with a Cloud KMS symmetric key, using application default credentials.
+// KMS encrypts with the key's primary version, so rotating the key in KMS and then calling the
+// rotation endpoint moves stored values to the new version.
+type KMS struct {
+	Key string
+}
+
+type kmsRequest struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	// Name is the key version used to encrypt
+	Name       string `json:"name"`
+	Plaintext  string `json:"plaintext"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+func (KMS) Name() string {
+	return kmsProvider
+}
+
+func (k KMS) Wrap(dek []byte) ([]byte, string, error) {
+	resp, err := k.do("encrypt", kmsRequest{Plaintext: base64.StdEncoding.EncodeToString(dek)})
+	if err != nil {
+		return nil, "", err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(resp.Ciphertext)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return wrapped, resp.Name, nil
+}
+
+// Unwrap decrypts a data key. KMS finds the key version from the ciphertext, so keyId is only
+// informational.
+func (k KMS) Unwrap(wrapped []byte, keyId string) ([]byte, error) {
+	resp, err := k.do("decrypt", kmsRequest{Ciphertext: base64.StdEncoding.EncodeToString(wrapped)})
+	if err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+func (k KMS) do(method string, body kmsRequest) (kmsResponse, error) {
+	ctx := context.Background()
+
+	client, err := google.DefaultClient(ctx, kmsScope)
+	if err != nil {
+		return kmsResponse{}, err
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return kmsResponse{}, err
+	}
+
+	uri := fmt.Sprintf("%s/%s:%s", kmsBaseUri, k.Key, method)
+	resp, err := client.Post(uri, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return kmsResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return kmsResponse{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return kmsResponse{}, fmt.Errorf("error from %s, %d: %s", uri, resp.StatusCode, b)
+	}
+
+	var r kmsResponse
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return kmsResponse{}, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,118 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const localProvider = "local"
+
+// ENCRYPTION_KEY_FILE is the path to a local key file for self-hosting
+var keyPath = os.Getenv("ENCRYPTION_KEY_FILE")
+
+// Local wraps data keys with AES-256 keys read from a JSON file:
+//
+//	{"current": "2024-01", "keys": {"2023-06": "<base64>", "2024-01": "<base64>"}}
+//
+// To rotate, add a key, make it current and call the rotation endpoint. Old keys stay in the file
+// until nothing is sealed with them.
+type Local struct {
+	Path string
+}
+
+type keys struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func (Local) Name() string {
+	return localProvider
+}
+
+func (l Local) Wrap(dek []byte) ([]byte, string, error) {
+	id, err := l.currentId()
+	if err != nil {
+		return nil, "", err
+	}
+
+	kek, err := l.key(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nonce, ciphertext, err := encrypt(kek, dek, []byte(id))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return append(nonce, ciphertext...), id, nil
+}
+
+func (l Local) Unwrap(wrapped []byte, keyId string) ([]byte, error) {
+	kek, err := l.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+
+	return decrypt(kek, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyId))
+}
+
+// load reads the key file. It is read on every use, so a rotated file takes effect without a
+// restart.
+func (l Local) load() (keys, error) {
+	b, err := os.ReadFile(l.Path)
+	if err != nil {
+		return keys{}, fmt.Errorf("error reading key file: %v", err)
+	}
+
+	var f keys
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return keys{}, fmt.Errorf("error parsing key file: %v", err)
+	}
+
+	return f, nil
+}
+
+func (l Local) currentId() (string, error) {
+	f, err := l.load()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := f.Keys[f.Current]; !ok {
+		return "", fmt.Errorf("current key %q isn't in the key file", f.Current)
+	}
+	return f.Current, nil
+}
+
+func (l Local) key(id string) ([]byte, error) {
+	f, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := f.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %q isn't in the key file", id)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %v", id, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %q: want 32 bytes, got %d", id, len(key))
+	}
+
+	return key, nil
+}
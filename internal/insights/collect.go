@@ -0,0 +1,151 @@
+package insights
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/auth"
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+)
+
+// destination is the post log destination insights are collected for
+const destination = "instagram"
+
+// interval is a time after publishing that insights are snapshotted
+type interval struct {
+	name  string
+	after time.Duration
+}
+
+// intervals are when insights are snapshotted, oldest last. Engagement mostly settles within a
+// week, and the last snapshot catches the long tail.
+var intervals = []interval{
+	{"1d", 24 * time.Hour},
+	{"3d", 3 * 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"28d", 28 * 24 * time.Hour},
+}
+
+// Result is the outcome of collecting one post's insights
+type Result struct {
+	Date  string `json:"date"`
+	Kind  string `json:"kind"`
+	After string `json:"after"`
+	Error string `json:"error,omitempty"`
+}
+
+// Collect snapshots the insights of every published Instagram post that has reached an interval
+// it has no snapshot for. Each post is fetched at most once a run, recorded against the latest
+// interval it has reached, so a missed run skips the earlier interval rather than repeating a
+// snapshot.
+func Collect(now time.Time) ([]Result, error) {
+	last := intervals[len(intervals)-1].after
+	// Intervals count from publishing, which for a backfilled post can be days after its date,
+	// so posts are found by when they were published. Two days' slack covers missed runs.
+	posts, err := database.GetPublishedPosts(now.Add(-last).AddDate(0, 0, -2))
+	if err != nil {
+		return nil, fmt.Errorf("error getting posts: %v", err)
+	}
+
+	// Posts logged before PublishedAt was recorded are found by date instead. Dates are local,
+	// and a post can be published the day after its date. Hack to avoid loading tz files.
+	from := now.Add(10*time.Hour-last).AddDate(0, 0, -2).Format("2006-01-02")
+	to := now.Add(10 * time.Hour).Format("2006-01-02")
+	dated, err := database.GetPosts(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error getting posts: %v", err)
+	}
+	for _, p := range dated {
+		if p.PublishedAt.IsZero() {
+			posts = append(posts, p)
+		}
+	}
+
+	var account auth.Account
+	results := make([]Result, 0)
+	seen := make(map[string]bool)
+	for _, p := range posts {
+		if p.Destination != destination || p.Status != database.PostPublished || p.MediaId == "" {
+			continue
+		}
+		// A backfilled carousel is logged under every date in it, but only the first date
+		// collects its insights
+		if seen[p.MediaId] {
+			continue
+		}
+		seen[p.MediaId] = true
+
+		due, ok := dueInterval(p, now)
+		if !ok {
+			continue
+		}
+
+		if account.PageToken == "" {
+			account, _, err = auth.GetAccount()
+			if err != nil {
+				return results, fmt.Errorf("error getting account: %v", err)
+			}
+		}
+
+		result := Result{Date: p.Date, Kind: p.Kind, After: due.name}
+		err = snapshot(&p, due, account, now)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// dueInterval returns the latest interval the post has reached without a snapshot for it or a
+// later interval
+func dueInterval(p database.PostDocument, now time.Time) (interval, bool) {
+	published := p.PublishedAt
+	if published.IsZero() {
+		// Posts logged before PublishedAt was recorded were published when last updated
+		published = p.UpdatedAt
+	}
+	age := now.Sub(published)
+
+	taken := make(map[string]bool)
+	for _, s := range p.Insights {
+		taken[s.After] = true
+	}
+
+	for i := len(intervals) - 1; i >= 0; i-- {
+		if taken[intervals[i].name] {
+			return interval{}, false
+		}
+		if age >= intervals[i].after {
+			return intervals[i], true
+		}
+	}
+	return interval{}, false
+}
+
+func snapshot(p *database.PostDocument, due interval, account auth.Account, now time.Time) error {
+	m, err := meta.Retry(func() (meta.MediaInsights, error) {
+		return meta.GetMediaInsights(p.MediaId, account.PageToken)
+	})
+	if err != nil {
+		return fmt.Errorf("error getting insights: %v", err)
+	}
+
+	p.Insights = append(p.Insights, database.Insights{
+		After:    due.name,
+		At:       now,
+		Reach:    m.Metric("reach"),
+		Views:    m.Metric("views"),
+		Likes:    m.LikeCount,
+		Comments: m.CommentsCount,
+		Saves:    m.Metric("saved"),
+	})
+
+	err = p.InsertOrUpdate()
+	if err != nil {
+		return fmt.Errorf("error saving insights: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package insights
+
+import (
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta/fakegraph"
+)
+
+var fake *fakegraph.Server
+
+func TestMain(m *testing.M) {
+	fake = fakegraph.New()
+	graph := httptest.NewServer(fake)
+	meta.SetBaseUri(graph.URL)
+
+	code := m.Run()
+
+	graph.Close()
+	os.Exit(code)
+}
+
+// logPublished publishes an image on the fake and logs it as published at publishedAt
+func logPublished(t *testing.T, date string, publishedAt time.Time) database.PostDocument {
+	t.Helper()
+
+	containerId, err := meta.CreateContainer(fake.IgId, "https://example.com/"+date+".jpg", date, fake.PageToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaId, err := meta.PublishContent(fake.IgId, containerId, fake.PageToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := database.PostDocument{
+		Date:        date,
+		Kind:        "daily",
+		Destination: destination,
+		MediaId:     mediaId,
+		Status:      database.PostPublished,
+		UpdatedAt:   publishedAt,
+		PublishedAt: publishedAt,
+	}
+	if err = p.InsertOrUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestCollectBackfilled takes the last snapshot of a post backfilled weeks after its date, which
+// falls outside a window of dates
+func TestCollectBackfilled(t *testing.T) {
+	t.Cleanup(database.UseMemory())
+	fake.Reset()
+
+	token := database.TokenDocument{
+		Token:     fake.IssueToken(0),
+		UserId:    fake.UserId,
+		Valid:     true,
+		PageId:    fake.PageId,
+		PageToken: fake.PageToken(),
+		IgId:      fake.IgId,
+	}
+	if err := token.InsertOrUpdate(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 6, 30, 12, 0, 0, 0, time.UTC)
+	backfilled := logPublished(t, "2023-05-10", now.Add(-29*24*time.Hour))
+	recent := logPublished(t, "2023-06-28", now.Add(-30*time.Hour))
+
+	results, err := Collect(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{backfilled.Date: "28d", recent.Date: "1d"}
+	if len(results) != len(want) {
+		t.Fatalf("collected %+v, want %v", results, want)
+	}
+	for _, r := range results {
+		if r.Error != "" || want[r.Date] != r.After {
+			t.Errorf("collected %+v, want %s after %s", r, r.Date, want[r.Date])
+		}
+	}
+
+	p, _, err := database.GetPost(backfilled.Date, backfilled.Kind, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := p.LatestInsights(); !ok || s.After != "28d" {
+		t.Errorf("insights %+v, want a 28d snapshot", p.Insights)
+	}
+}
@@ -0,0 +1,145 @@
+package insights
+
+import (
+	"math"
+
+	"github.com/baely/weightloss-tracker/internal/analysis"
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/publish"
+)
+
+// Day is a daily post's latest engagement alongside the progress it showed
+type Day struct {
+	Date       string `json:"date"`
+	Reach      int    `json:"reach"`
+	Engagement int    `json:"engagement"`
+	// TrendDelta is the change in trend weight over the week to the post's date
+	TrendDelta float64 `json:"trendDelta"`
+	Streak     int     `json:"streak"`
+}
+
+// Group is the average engagement of a set of posts
+type Group struct {
+	Posts             int     `json:"posts"`
+	AverageReach      float64 `json:"averageReach"`
+	AverageEngagement float64 `json:"averageEngagement"`
+}
+
+// Report relates engagement with daily posts to the weight trend and logging streak they showed.
+// Correlations are Pearson coefficients, 0 when there are too few posts to say.
+type Report struct {
+	All Group `json:"all"`
+	// Losing are posts from weeks the trend weight fell, Gaining the rest
+	Losing  Group `json:"losing"`
+	Gaining Group `json:"gaining"`
+	// TrendCorrelation relates engagement to the week's trend change. Negative means weeks with
+	// more loss got more engagement.
+	TrendCorrelation  float64 `json:"trendCorrelation"`
+	StreakCorrelation float64 `json:"streakCorrelation"`
+	Days              []Day   `json:"days"`
+}
+
+// BuildReport builds the report from the post log and documents. Only daily Instagram posts with
+// insights are included, and a backfilled carousel logged under several dates only counts once,
+// for its first date.
+func BuildReport(posts []database.PostDocument, docs []database.Document, settings database.Settings) Report {
+	rule := analysis.RuleFromSettings(settings)
+
+	r := Report{Days: make([]Day, 0)}
+	var engagement, trend, streak []float64
+	var all, losing, gaining groupSum
+	seen := make(map[string]bool)
+	for _, p := range posts {
+		if p.Destination != destination || p.Kind != publish.KindDaily {
+			continue
+		}
+		if p.MediaId != "" && seen[p.MediaId] {
+			continue
+		}
+		seen[p.MediaId] = true
+		latest, ok := p.LatestInsights()
+		if !ok {
+			continue
+		}
+
+		day := Day{
+			Date:       p.Date,
+			Reach:      latest.Reach,
+			Engagement: latest.Engagement(),
+			TrendDelta: analysis.ComputeProgress(docs, p.Date).TrendDelta,
+			Streak:     analysis.ComputeStreaks(docs, p.Date, rule).Complete.Current,
+		}
+		r.Days = append(r.Days, day)
+
+		engagement = append(engagement, float64(day.Engagement))
+		trend = append(trend, day.TrendDelta)
+		streak = append(streak, float64(day.Streak))
+
+		all.add(day)
+		if day.TrendDelta < 0 {
+			losing.add(day)
+		} else {
+			gaining.add(day)
+		}
+	}
+
+	r.All, r.Losing, r.Gaining = all.group(), losing.group(), gaining.group()
+	r.TrendCorrelation = correlation(engagement, trend)
+	r.StreakCorrelation = correlation(engagement, streak)
+
+	return r
+}
+
+type groupSum struct {
+	posts      int
+	reach      int
+	engagement int
+}
+
+func (g *groupSum) add(d Day) {
+	g.posts++
+	g.reach += d.Reach
+	g.engagement += d.Engagement
+}
+
+func (g groupSum) group() Group {
+	if g.posts == 0 {
+		return Group{}
+	}
+	return Group{
+		Posts:             g.posts,
+		AverageReach:      float64(g.reach) / float64(g.posts),
+		AverageEngagement: float64(g.engagement) / float64(g.posts),
+	}
+}
+
+// minCorrelationPosts is the fewest posts a correlation is worked out from
+const minCorrelationPosts = 5
+
+// correlation returns the Pearson correlation coefficient of xs and ys, rounded to two places
+func correlation(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	if len(xs) < minCorrelationPosts || len(xs) != len(ys) {
+		return 0
+	}
+
+	var sx, sy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+	}
+	mx, my := sx/n, sy/n
+
+	var cov, vx, vy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0
+	}
+
+	return math.Round(cov/math.Sqrt(vx*vy)*100) / 100
+}
@@ -0,0 +1,142 @@
+package bluesky
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const defaultPds = "https://bsky.social"
+
+var (
+	pds         = pdsAddress()
+	handle      = os.Getenv("BLUESKY_HANDLE")
+	appPassword = os.Getenv("BLUESKY_APP_PASSWORD")
+)
+
+// Session is an authenticated AT Protocol session
+type Session struct {
+	AccessJwt string `json:"accessJwt"`
+	Did       string `json:"did"`
+}
+
+// Blob is a reference to uploaded data, embedded in records as is
+type Blob struct {
+	Type     string `json:"$type"`
+	Ref      Link   `json:"ref"`
+	MimeType string `json:"mimeType"`
+	Size     int    `json:"size"`
+}
+
+type Link struct {
+	Link string `json:"$link"`
+}
+
+type image struct {
+	Alt   string `json:"alt"`
+	Image Blob   `json:"image"`
+}
+
+type post struct {
+	Type      string `json:"$type"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"createdAt"`
+	Embed     struct {
+		Type   string  `json:"$type"`
+		Images []image `json:"images"`
+	} `json:"embed"`
+}
+
+// Record identifies a created record
+type Record struct {
+	Uri string `json:"uri"`
+	Cid string `json:"cid"`
+}
+
+func pdsAddress() string {
+	if v := os.Getenv("BLUESKY_PDS"); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return defaultPds
+}
+
+// Configured reports whether a handle and app password are set
+func Configured() bool {
+	return handle != "" && appPassword != ""
+}
+
+// CreateSession logs in with the app password
+func CreateSession() (Session, error) {
+	body, err := json.Marshal(map[string]string{"identifier": handle, "password": appPassword})
+	if err != nil {
+		return Session{}, err
+	}
+
+	var s Session
+	err = do("com.atproto.server.createSession", "", "application/json", body, &s)
+	return s, err
+}
+
+// UploadBlob uploads an image for embedding in a post
+func UploadBlob(s Session, img []byte, mimeType string) (Blob, error) {
+	var resp struct {
+		Blob Blob `json:"blob"`
+	}
+	err := do("com.atproto.repo.uploadBlob", s.AccessJwt, mimeType, img, &resp)
+	return resp.Blob, err
+}
+
+// CreatePost publishes a post with one image
+func CreatePost(s Session, text string, img Blob, alt string) (Record, error) {
+	p := post{Type: "app.bsky.feed.post", Text: text, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	p.Embed.Type = "app.bsky.embed.images"
+	p.Embed.Images = []image{{Alt: alt, Image: img}}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"repo":       s.Did,
+		"collection": "app.bsky.feed.post",
+		"record":     p,
+	})
+	if err != nil {
+		return Record{}, err
+	}
+
+	var r Record
+	err = do("com.atproto.repo.createRecord", s.AccessJwt, "application/json", body, &r)
+	return r, err
+}
+
+func do(method, jwt, contentType string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/xrpc/%s", pds, method), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+jwt)
+	}
+
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error from %s, %d: %s", method, resp.StatusCode, b)
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,67 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+var webhookUrl = os.Getenv("DISCORD_WEBHOOK_URL")
+
+type embed struct {
+	Image struct {
+		Url string `json:"url"`
+	} `json:"image"`
+}
+
+type message struct {
+	Content string  `json:"content"`
+	Embeds  []embed `json:"embeds"`
+}
+
+// Configured reports whether a webhook is set
+func Configured() bool {
+	return webhookUrl != ""
+}
+
+// PostImage posts a message embedding a publicly reachable image and returns the message ID
+func PostImage(imageUrl, content string) (string, error) {
+	m := message{Content: content, Embeds: make([]embed, 1)}
+	m.Embeds[0].Image.Url = imageUrl
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	// wait=true makes Discord return the created message rather than 204 No Content
+	resp, err := util.HTTPClient.Post(webhookUrl+"?wait=true", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", util.RedactURL(err, "discord webhook")
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error from discord webhook, %d: %s", resp.StatusCode, b)
+	}
+
+	var created struct {
+		Id string `json:"id"`
+	}
+	err = json.Unmarshal(b, &created)
+	if err != nil {
+		return "", err
+	}
+
+	return created.Id, nil
+}
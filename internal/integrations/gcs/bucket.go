@@ -1,13 +1,48 @@
 package gcs
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
+// localDir holds bucket objects as files, one directory per bucket, in place of Cloud Storage
+// while set by UseDir
+var localDir string
+
+// UseDir keeps bucket objects as files under dir, in a directory per bucket, instead of in Cloud
+// Storage, so a server run locally doesn't read or overwrite what's deployed. Objects missing
+// from dir are missing, so templates, fonts and captions fall back to the built-in ones.
+func UseDir(dir string) {
+	localDir = dir
+}
+
+func localPath(bucket, object string) string {
+	return filepath.Join(localDir, bucket, filepath.FromSlash(object))
+}
+
 func UploadFile(bucket string, object string, file io.Reader) error {
+	if localDir != "" {
+		b, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		name := localPath(bucket, object)
+		if err = os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(name, b, 0o644)
+	}
+
 	ctx := context.Background()
 
 	client, err := storage.NewClient(ctx)
@@ -32,6 +67,17 @@ func UploadFile(bucket string, object string, file io.Reader) error {
 }
 
 func ReadFile(bucket string, object string) (io.Reader, error) {
+	if localDir != "" {
+		b, err := os.ReadFile(localPath(bucket, object))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", storage.ErrObjectNotExist, localPath(bucket, object))
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(b), nil
+	}
+
 	ctx := context.Background()
 
 	client, err := storage.NewClient(ctx)
@@ -49,3 +95,67 @@ func ReadFile(bucket string, object string) (io.Reader, error) {
 
 	return r, nil
 }
+
+// ListFiles returns the names of the objects in a bucket that start with prefix
+func ListFiles(bucket string, prefix string) ([]string, error) {
+	if localDir != "" {
+		return listLocalFiles(bucket, prefix)
+	}
+
+	ctx := context.Background()
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	bkt := client.Bucket(bucket)
+	it := bkt.Objects(ctx, &storage.Query{Prefix: prefix})
+
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+
+	return names, nil
+}
+
+// IsNotExist reports whether err is the error returned when reading an object that doesn't exist
+func IsNotExist(err error) bool {
+	return errors.Is(err, storage.ErrObjectNotExist)
+}
+
+func listLocalFiles(bucket string, prefix string) ([]string, error) {
+	root := filepath.Join(localDir, bucket)
+
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
@@ -0,0 +1,43 @@
+package gcs
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalDir(t *testing.T) {
+	UseDir(t.TempDir())
+	defer UseDir("")
+
+	if err := UploadFile("res.example", "weightlog/2023-05-01.png", strings.NewReader("card")); err != nil {
+		t.Fatal(err)
+	}
+	if err := UploadFile("res.example", "weightlog/timelapse/2023-05-cards.gif", strings.NewReader("gif")); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ReadFile("res.example", "weightlog/2023-05-01.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "card" {
+		t.Errorf("read %q, want %q", b, "card")
+	}
+
+	if _, err = ReadFile("res.example", "weightlog/2023-05-02.png"); !IsNotExist(err) {
+		t.Errorf("reading a missing object returned %v, want it not to exist", err)
+	}
+
+	names, err := ListFiles("res.example", "weightlog/timelapse/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "weightlog/timelapse/2023-05-cards.gif" {
+		t.Errorf("listed %q", names)
+	}
+
+	if names, err = ListFiles("static.example", ""); err != nil || len(names) != 0 {
+		t.Errorf("listing an empty bucket returned %q, %v", names, err)
+	}
+}
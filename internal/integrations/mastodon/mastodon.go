@@ -0,0 +1,105 @@
+package mastodon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+var (
+	server = strings.TrimSuffix(os.Getenv("MASTODON_SERVER"), "/")
+	token  = os.Getenv("MASTODON_TOKEN")
+)
+
+type Media struct {
+	Id string `json:"id"`
+}
+
+type Status struct {
+	Id  string `json:"id"`
+	Url string `json:"url"`
+}
+
+// Configured reports whether a server and access token are set
+func Configured() bool {
+	return server != "" && token != ""
+}
+
+// UploadMedia uploads an image of the given content type and returns its media ID
+func UploadMedia(img []byte, filename, contentType, description string) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	header.Set("Content-Type", contentType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(img); err != nil {
+		return "", err
+	}
+	if err = w.WriteField("description", description); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	var media Media
+	err = do(http.MethodPost, "/api/v2/media", w.FormDataContentType(), &body, &media)
+	if err != nil {
+		return "", err
+	}
+
+	return media.Id, nil
+}
+
+// PostStatus publishes a status with attached media and returns it
+func PostStatus(text string, mediaIds ...string) (Status, error) {
+	form := url.Values{"status": []string{text}}
+	for _, id := range mediaIds {
+		form.Add("media_ids[]", id)
+	}
+
+	var status Status
+	err := do(http.MethodPost, "/api/v1/statuses", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), &status)
+	return status, err
+}
+
+func do(method, path, contentType string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest(method, server+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := util.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Media is processed asynchronously and returns 202, which is still usable as an attachment
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("error from %s, %d: %s", path, resp.StatusCode, b)
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,72 @@
+package meta
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	defaultGraphBaseUri    = "https://graph.facebook.com"
+	defaultFacebookBaseUri = "https://www.facebook.com"
+
+	defaultApiVersion  = "v17.0"
+	defaultPageName    = "Blw"
+	defaultRedirectUri = "https://weight.xbd.au/new-token"
+)
+
+var defaultScopes = []string{"instagram_basic", "pages_show_list", "business_management", "instagram_content_publish", "instagram_manage_insights"}
+
+var (
+	// graphBaseUri is where Graph API requests go, GRAPH_BASE_URL to use a fake Graph API
+	graphBaseUri = strings.TrimSuffix(envOr("GRAPH_BASE_URL", defaultGraphBaseUri), "/")
+	// facebookBaseUri serves the OAuth dialog. A fake Graph API serves it too, so it follows
+	// GRAPH_BASE_URL unless FACEBOOK_BASE_URL is set.
+	facebookBaseUri = strings.TrimSuffix(envOr("FACEBOOK_BASE_URL", envOr("GRAPH_BASE_URL", defaultFacebookBaseUri)), "/")
+
+	appId     = os.Getenv("IG_APP_ID")
+	appSecret = os.Getenv("IG_SECRET")
+	// pageId picks the Facebook page posted from. Without it the page is found by pageName.
+	pageId   = os.Getenv("IG_PAGE_ID")
+	pageName = envOr("IG_PAGE_NAME", defaultPageName)
+	// apiVersion is the Graph API version, such as "v17.0"
+	apiVersion = version(envOr("GRAPH_API_VERSION", defaultApiVersion))
+	// callbackUri is where the OAuth dialog redirects with the code, the deployment's /new-token
+	callbackUri = envOr("IG_REDIRECT_URI", defaultRedirectUri)
+	// scopes are the permissions requested in the OAuth dialog, comma separated in IG_SCOPES
+	scopes = list(envOr("IG_SCOPES", strings.Join(defaultScopes, ",")))
+)
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// version accepts a Graph API version with or without its "v"
+func version(v string) string {
+	return "v" + strings.TrimPrefix(v, "v")
+}
+
+func list(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SetBaseUri sends Graph API requests and the OAuth dialog to uri, such as a fake Graph API
+// started by a program exercising this package
+func SetBaseUri(uri string) {
+	graphBaseUri = strings.TrimSuffix(uri, "/")
+	facebookBaseUri = graphBaseUri
+}
+
+// graphUri returns the address of a versioned Graph API path
+func graphUri(format string, a ...interface{}) string {
+	return fmt.Sprintf("%s/%s/%s", graphBaseUri, apiVersion, fmt.Sprintf(format, a...))
+}
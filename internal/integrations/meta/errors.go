@@ -0,0 +1,139 @@
+package meta
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// ErrorClass groups Graph API errors by what should be done about them
+type ErrorClass string
+
+const (
+	// ErrorTransient errors may succeed if the request is repeated later
+	ErrorTransient ErrorClass = "transient"
+	// ErrorAuth errors need the token replaced, by reauthorising at AuthUrl
+	ErrorAuth ErrorClass = "auth"
+	// ErrorMedia errors mean Instagram can't use the image, such as an unreachable URL
+	ErrorMedia ErrorClass = "media"
+	// ErrorPermanent errors won't succeed by repeating the request
+	ErrorPermanent ErrorClass = "permanent"
+)
+
+// Error is an error response from the Graph API
+type Error struct {
+	Uri        string
+	StatusCode int
+	Message    string
+	Type       string
+	Code       int
+	Subcode    int
+	Transient  bool
+	Class      ErrorClass
+	// Body is the raw response, for responses that aren't a Graph error
+	Body string
+}
+
+type errorResp struct {
+	Error struct {
+		Message      string `json:"message"`
+		Type         string `json:"type"`
+		Code         int    `json:"code"`
+		ErrorSubcode int    `json:"error_subcode"`
+		IsTransient  bool   `json:"is_transient"`
+	} `json:"error"`
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.StatusCode == 0:
+		// Not from a response, such as a container that failed processing
+		return fmt.Sprintf("error from %s: %s (%s)", e.Uri, e.Message, e.Class)
+	case e.Message == "":
+		return fmt.Sprintf("error from %s, %d: %s", e.Uri, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("error from %s, %d: %s (code %d, subcode %d, %s)", e.Uri, e.StatusCode, e.Message, e.Code, e.Subcode, e.Class)
+}
+
+// Retryable reports whether the request may succeed if repeated
+func (e *Error) Retryable() bool {
+	return e.Class == ErrorTransient
+}
+
+func parseError(uri string, statusCode int, body []byte) *Error {
+	e := &Error{Uri: uri, StatusCode: statusCode}
+
+	var resp errorResp
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error.Message == "" {
+		e.Body = string(body)
+	} else {
+		e.Message = resp.Error.Message
+		e.Type = resp.Error.Type
+		e.Code = resp.Error.Code
+		e.Subcode = resp.Error.ErrorSubcode
+		e.Transient = resp.Error.IsTransient
+	}
+	e.Class = classify(e)
+
+	return e
+}
+
+// classify sorts an error using the codes in the Graph API and Instagram content publishing
+// error references
+func classify(e *Error) ErrorClass {
+	switch {
+	case e.Transient:
+		return ErrorTransient
+	// 2207027: the container isn't ready to publish yet
+	case e.Code == 9007 && e.Subcode == 2207027:
+		return ErrorTransient
+	// 1, 2: unknown and temporary errors. 4, 17, 32, 613: rate limits
+	case e.Code == 1 || e.Code == 2 || e.Code == 4 || e.Code == 17 || e.Code == 32 || e.Code == 613:
+		return ErrorTransient
+	// 102, 190: the session or token is invalid or expired. 10, 200-299: missing permissions
+	case e.Code == 102 || e.Code == 190 || e.Code == 10 || (e.Code >= 200 && e.Code <= 299):
+		return ErrorAuth
+	// 9004: the image URL couldn't be fetched or isn't a supported image. 36000-36003: the image
+	// is too large, an unsupported format or has an unsupported aspect ratio
+	case e.Code == 9004 || (e.Code >= 36000 && e.Code <= 36003):
+		return ErrorMedia
+	case e.Subcode == 2207052 || e.Subcode == 2207026 || e.Subcode == 2207009:
+		return ErrorMedia
+	case e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests:
+		return ErrorTransient
+	}
+	return ErrorPermanent
+}
+
+// Retryable reports whether a request that failed with err may succeed if repeated. Network
+// errors are retryable; errors other than Graph and network errors aren't.
+func Retryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Class returns the class of a Graph API error. Network errors are transient and other errors
+// are permanent.
+func Class(err error) ErrorClass {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	if Retryable(err) {
+		return ErrorTransient
+	}
+	return ErrorPermanent
+}
+
+// TokenRejected reports whether err is the Graph API rejecting the access token itself, as
+// opposed to the token lacking a permission
+func TokenRejected(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.Code == 190 || e.Code == 102)
+}
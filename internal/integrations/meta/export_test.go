@@ -0,0 +1,13 @@
+package meta
+
+import "time"
+
+// SetDelays shortens the waits between retries and between container status reads, returning a
+// function that restores them
+func SetDelays(backoff, poll time.Duration) func() {
+	oldBackoff, oldPoll := retryBackoff, containerPollInterval
+	retryBackoff, containerPollInterval = backoff, poll
+	return func() {
+		retryBackoff, containerPollInterval = oldBackoff, oldPoll
+	}
+}
@@ -0,0 +1,75 @@
+package fakegraph
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// control serves the fake's own endpoints, for scripting a running fake:
+//
+//	POST /_fake/fail?endpoint=media&failure=rate_limit&times=2
+//	POST /_fake/reset
+//	GET  /_fake/published
+//
+// It is called with the lock held.
+func (s *Server) control(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/_fake/") {
+	case "fail":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		endpoint, f, times, err := ParseFailure(r.FormValue("endpoint"), r.FormValue("failure"), r.FormValue("times"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i := 0; i < times; i++ {
+			s.failures[endpoint] = append(s.failures[endpoint], f)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "reset":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.failures = make(map[Endpoint][]Failure)
+		s.calls = make(map[Endpoint]int)
+		w.WriteHeader(http.StatusNoContent)
+	case "published":
+		writeJSON(w, append(make([]*Published, 0, len(s.published)), s.published...))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ParseFailure reads a scripted failure from an endpoint, a name from Failures and a number of
+// times, 1 if empty
+func ParseFailure(endpoint, failure, times string) (Endpoint, Failure, int, error) {
+	e := Endpoint(endpoint)
+	known := false
+	for _, name := range Endpoints {
+		known = known || name == e
+	}
+	if !known {
+		return "", Failure{}, 0, fmt.Errorf("unknown endpoint %q", endpoint)
+	}
+
+	f, ok := Failures[failure]
+	if !ok {
+		return "", Failure{}, 0, fmt.Errorf("unknown failure %q", failure)
+	}
+
+	n := 1
+	if times != "" {
+		var err error
+		n, err = strconv.Atoi(times)
+		if err != nil || n < 1 {
+			return "", Failure{}, 0, fmt.Errorf("times must be a positive number, got %q", times)
+		}
+	}
+
+	return e, f, n, nil
+}
@@ -0,0 +1,614 @@
+// Package fakegraph is a fake of the parts of the Graph API the meta package uses: the OAuth
+// dialog and token exchanges, debug_token, page discovery, media containers, publishing,
+// container status and media insights. Failures can be scripted per endpoint, so posting can be
+// exercised without a real app, page or token.
+package fakegraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint names a group of Graph API requests that failures can be scripted for
+type Endpoint string
+
+const (
+	// AccessToken is the app token, code and long-lived token exchanges
+	AccessToken Endpoint = "access_token"
+	DebugToken  Endpoint = "debug_token"
+	Accounts    Endpoint = "accounts"
+	// Page is reading the page's Instagram business account
+	Page    Endpoint = "page"
+	Media   Endpoint = "media"
+	Publish Endpoint = "media_publish"
+	// ContainerStatus is reading a container's processing status
+	ContainerStatus Endpoint = "container_status"
+	Insights        Endpoint = "insights"
+	// Processing isn't a request: its failures make the next containers created end in ERROR
+	// with the failure's message instead of finishing
+	Processing Endpoint = "processing"
+)
+
+// Endpoints are every endpoint failures can be scripted for
+var Endpoints = []Endpoint{AccessToken, DebugToken, Accounts, Page, Media, Publish, ContainerStatus, Insights, Processing}
+
+// Failure is an error response from the Graph API
+type Failure struct {
+	// StatusCode is the HTTP status, 400 if zero
+	StatusCode int
+	Code       int
+	Subcode    int
+	Message    string
+	Transient  bool
+}
+
+// Failures are errors the Graph API returns, by name
+var Failures = map[string]Failure{
+	"rate_limit":    {Code: 4, Message: "Application request limit reached", Transient: true},
+	"server_error":  {StatusCode: http.StatusInternalServerError, Code: 2, Message: "An unexpected error has occurred. Please retry your request later.", Transient: true},
+	"token_expired": {Code: 190, Subcode: 463, Message: "Error validating access token: Session has expired"},
+	"permission":    {Code: 10, Message: "Application does not have permission for this action"},
+	"bad_image":     {Code: 9004, Subcode: 2207052, Message: "Media download has failed. The media URI doesn't meet our requirements."},
+	"not_ready":     {Code: 9007, Subcode: 2207027, Message: "Media ID is not available"},
+	"invalid":       {Code: 100, Message: "Invalid parameter"},
+}
+
+const (
+	defaultTokenLifetime = 60 * 24 * time.Hour
+	shortTokenLifetime   = time.Hour
+)
+
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+(\.[0-9]+)?/`)
+
+// Server is a fake Graph API with one user, who manages one page linked to one Instagram business
+// account. Its fields can be changed before it serves requests.
+type Server struct {
+	UserId   string
+	PageId   string
+	PageName string
+	IgId     string
+	Scopes   []string
+	// TokenLifetime is how long exchanged long-lived tokens last
+	TokenLifetime time.Duration
+
+	mu sync.Mutex
+	// processingPolls is how many status reads a new container stays IN_PROGRESS for
+	processingPolls int
+	next            int
+	pageToken       string
+	tokens          map[string]*token
+	codes           map[string]bool
+	containers      map[string]*container
+	media           map[string]*Published
+	published       []*Published
+	failures        map[Endpoint][]Failure
+	calls           map[Endpoint]int
+}
+
+type token struct {
+	issuedAt  time.Time
+	expiresAt time.Time
+	revoked   bool
+}
+
+type container struct {
+	id           string
+	imageUrl     string
+	caption      string
+	children     []string
+	carouselItem bool
+	polls        int
+	// processing is how many status reads it stays IN_PROGRESS for
+	processing int
+	failure    *Failure
+	published  bool
+}
+
+// Published is media published through the fake, with the insights it reports
+type Published struct {
+	Id          string    `json:"id"`
+	ContainerId string    `json:"containerId"`
+	Caption     string    `json:"caption"`
+	ImageUrls   []string  `json:"imageUrls"`
+	At          time.Time `json:"at"`
+	Reach       int       `json:"reach"`
+	Views       int       `json:"views"`
+	Likes       int       `json:"likes"`
+	Comments    int       `json:"comments"`
+	Saves       int       `json:"saves"`
+}
+
+// New returns a fake whose page is named "Blw", the page the meta package looks for by default
+func New() *Server {
+	s := &Server{
+		UserId:        "10000000000000001",
+		PageId:        "20000000000000001",
+		PageName:      "Blw",
+		IgId:          "17840000000000001",
+		Scopes:        []string{"instagram_basic", "pages_show_list", "business_management", "instagram_content_publish", "instagram_manage_insights"},
+		TokenLifetime: defaultTokenLifetime,
+		tokens:        make(map[string]*token),
+		codes:         make(map[string]bool),
+		containers:    make(map[string]*container),
+		media:         make(map[string]*Published),
+		failures:      make(map[Endpoint][]Failure),
+		calls:         make(map[Endpoint]int),
+	}
+	s.pageToken = s.issue(0)
+	return s
+}
+
+// Fail makes the next times requests to an endpoint fail with f, after any already scripted
+func (s *Server) Fail(endpoint Endpoint, f Failure, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < times; i++ {
+		s.failures[endpoint] = append(s.failures[endpoint], f)
+	}
+}
+
+// SetProcessingPolls makes new containers stay IN_PROGRESS for the next polls status reads before
+// finishing, rather than finishing straight away
+func (s *Server) SetProcessingPolls(polls int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processingPolls = polls
+}
+
+// Reset drops every scripted failure and call count
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = make(map[Endpoint][]Failure)
+	s.calls = make(map[Endpoint]int)
+}
+
+// Calls returns how many requests an endpoint has had, including failed ones
+func (s *Server) Calls(endpoint Endpoint) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[endpoint]
+}
+
+// IssueToken returns a new user token lasting lifetime, or one that never expires for 0
+func (s *Server) IssueToken(lifetime time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.issue(lifetime)
+}
+
+// Revoke makes a token invalid, as if the user removed the app or changed their password
+func (s *Server) Revoke(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.tokens[accessToken]; ok {
+		t.revoked = true
+	}
+}
+
+// PageToken returns the page's access token
+func (s *Server) PageToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pageToken
+}
+
+// Published returns the media published so far, oldest first
+func (s *Server) Published() []Published {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	published := make([]Published, 0, len(s.published))
+	for _, m := range s.published {
+		published = append(published, *m)
+	}
+	return published
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/_fake/") {
+		s.control(w, r)
+		return
+	}
+
+	path := versionPrefix.ReplaceAllString(r.URL.Path, "/")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case path == "/oauth/access_token":
+		s.accessToken(w, r)
+	case path == "/debug_token":
+		s.debugToken(w, r)
+	case path == "/dialog/oauth":
+		s.dialog(w, r)
+	case len(parts) == 2 && parts[1] == "accounts":
+		s.accounts(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "media" && r.Method == http.MethodPost:
+		s.createContainer(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "media_publish" && r.Method == http.MethodPost:
+		s.publish(w, r, parts[0])
+	case len(parts) == 1 && parts[0] == s.PageId:
+		s.page(w, r)
+	case len(parts) == 1 && s.containers[parts[0]] != nil:
+		s.containerStatus(w, r, s.containers[parts[0]])
+	case len(parts) == 1 && s.media[parts[0]] != nil:
+		s.insights(w, r, s.media[parts[0]])
+	default:
+		writeError(w, Failure{Code: 803, Message: fmt.Sprintf("Unknown path components: %s", r.URL.Path)})
+	}
+}
+
+// called counts a request to an endpoint and writes its next scripted failure, returning false
+// if there was one
+func (s *Server) called(w http.ResponseWriter, endpoint Endpoint) bool {
+	s.calls[endpoint]++
+
+	if f, ok := s.nextFailure(endpoint); ok {
+		writeError(w, f)
+		return false
+	}
+	return true
+}
+
+func (s *Server) nextFailure(endpoint Endpoint) (Failure, bool) {
+	queue := s.failures[endpoint]
+	if len(queue) == 0 {
+		return Failure{}, false
+	}
+	s.failures[endpoint] = queue[1:]
+	return queue[0], true
+}
+
+// authorised checks the request's access token is one the fake issued and is still valid, writing
+// the Graph API's invalid token error if not
+func (s *Server) authorised(w http.ResponseWriter, r *http.Request) bool {
+	if err := s.check(r.FormValue("access_token")); err != nil {
+		writeError(w, *err)
+		return false
+	}
+	return true
+}
+
+func (s *Server) check(accessToken string) *Failure {
+	t, ok := s.tokens[accessToken]
+	switch {
+	case !ok:
+		return &Failure{Code: 190, Message: "Invalid OAuth access token - Cannot parse access token"}
+	case t.revoked:
+		return &Failure{Code: 190, Subcode: 460, Message: "Error validating access token: The session has been invalidated"}
+	case !t.expiresAt.IsZero() && time.Now().After(t.expiresAt):
+		return &Failure{Code: 190, Subcode: 463, Message: "Error validating access token: Session has expired"}
+	}
+	return nil
+}
+
+func (s *Server) issue(lifetime time.Duration) string {
+	t := &token{issuedAt: time.Now()}
+	if lifetime > 0 {
+		t.expiresAt = t.issuedAt.Add(lifetime)
+	}
+	accessToken := fmt.Sprintf("fake-token-%s", s.id())
+	s.tokens[accessToken] = t
+	return accessToken
+}
+
+func (s *Server) id() string {
+	s.next++
+	return fmt.Sprintf("%d", 18000000000000000+s.next)
+}
+
+// dialog grants the requested permissions straight away, redirecting with a code
+func (s *Server) dialog(w http.ResponseWriter, r *http.Request) {
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		writeError(w, Failure{Code: 191, Message: "The redirect_uri URL must be absolute"})
+		return
+	}
+
+	code := fmt.Sprintf("fake-code-%s", s.id())
+	s.codes[code] = true
+
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", r.FormValue("state"))
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// accessToken handles the app token, code and long-lived token exchanges
+func (s *Server) accessToken(w http.ResponseWriter, r *http.Request) {
+	if !s.called(w, AccessToken) {
+		return
+	}
+
+	switch {
+	case r.FormValue("grant_type") == "client_credentials":
+		writeJSON(w, map[string]string{
+			"access_token": s.issue(0),
+			"token_type":   "bearer",
+		})
+	case r.FormValue("grant_type") == "fb_exchange_token":
+		if err := s.check(r.FormValue("fb_exchange_token")); err != nil {
+			writeError(w, *err)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"access_token": s.issue(s.TokenLifetime),
+			"token_type":   "bearer",
+			"expires_in":   int(s.TokenLifetime.Seconds()),
+		})
+	case r.FormValue("code") != "":
+		code := r.FormValue("code")
+		if !s.codes[code] {
+			writeError(w, Failure{Code: 100, Subcode: 36007, Message: "This authorization code has been used."})
+			return
+		}
+		delete(s.codes, code)
+		writeJSON(w, map[string]interface{}{
+			"access_token": s.issue(shortTokenLifetime),
+			"token_type":   "bearer",
+			"expires_in":   int(shortTokenLifetime.Seconds()),
+		})
+	default:
+		writeError(w, Failure{Code: 100, Message: "Missing grant_type, code or fb_exchange_token"})
+	}
+}
+
+// debugToken reports on input_token. Tokens that aren't valid are reported, not errors.
+func (s *Server) debugToken(w http.ResponseWriter, r *http.Request) {
+	if !s.called(w, DebugToken) || !s.authorised(w, r) {
+		return
+	}
+
+	data := map[string]interface{}{
+		"app_id":      "fake-app",
+		"type":        "USER",
+		"application": "Fake Graph API",
+		"is_valid":    false,
+	}
+
+	t, ok := s.tokens[r.FormValue("input_token")]
+	if ok {
+		data["user_id"] = s.UserId
+		data["scopes"] = s.Scopes
+		data["issued_at"] = t.issuedAt.Unix()
+		data["expires_at"] = 0
+		if !t.expiresAt.IsZero() {
+			data["expires_at"] = t.expiresAt.Unix()
+		}
+	}
+
+	if err := s.check(r.FormValue("input_token")); err != nil {
+		data["error"] = map[string]interface{}{
+			"code":    err.Code,
+			"subcode": err.Subcode,
+			"message": err.Message,
+		}
+	} else {
+		data["is_valid"] = true
+	}
+
+	writeJSON(w, map[string]interface{}{"data": data})
+}
+
+func (s *Server) accounts(w http.ResponseWriter, r *http.Request, userId string) {
+	if !s.called(w, Accounts) || !s.authorised(w, r) {
+		return
+	}
+	if userId != s.UserId && userId != "me" {
+		writeError(w, Failure{Code: 100, Subcode: 33, Message: fmt.Sprintf("Object with ID '%s' does not exist", userId)})
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"data": []map[string]interface{}{{
+			"name":         s.PageName,
+			"category":     "Personal blog",
+			"id":           s.PageId,
+			"access_token": s.pageToken,
+			"tasks":        []string{"ANALYZE", "ADVERTISE", "MODERATE", "CREATE_CONTENT", "MANAGE"},
+		}},
+	})
+}
+
+func (s *Server) page(w http.ResponseWriter, r *http.Request) {
+	if !s.called(w, Page) || !s.authorised(w, r) {
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"instagram_business_account": map[string]string{"id": s.IgId},
+		"id":                         s.PageId,
+	})
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request, igId string) {
+	if !s.called(w, Media) || !s.authorised(w, r) {
+		return
+	}
+	if igId != s.IgId {
+		writeError(w, Failure{Code: 100, Subcode: 33, Message: fmt.Sprintf("Object with ID '%s' does not exist", igId)})
+		return
+	}
+
+	c := &container{
+		id:           s.id(),
+		imageUrl:     r.FormValue("image_url"),
+		caption:      r.FormValue("caption"),
+		carouselItem: r.FormValue("is_carousel_item") == "true",
+		processing:   s.processingPolls,
+	}
+
+	if r.FormValue("media_type") == "CAROUSEL" {
+		c.children = strings.Split(r.FormValue("children"), ",")
+		if len(c.children) < 2 || len(c.children) > 10 {
+			writeError(w, Failure{Code: 100, Message: "The carousel must have 2 to 10 children"})
+			return
+		}
+		for _, id := range c.children {
+			if child, ok := s.containers[id]; !ok || !child.carouselItem {
+				writeError(w, Failure{Code: 100, Message: fmt.Sprintf("Child %s is not a carousel item container", id)})
+				return
+			}
+		}
+	} else if c.imageUrl == "" {
+		writeError(w, Failure{Code: 100, Message: "The parameter image_url is required"})
+		return
+	}
+
+	if f, ok := s.nextFailure(Processing); ok {
+		c.failure = &f
+	}
+	s.containers[c.id] = c
+
+	writeJSON(w, map[string]string{"id": c.id})
+}
+
+// status returns the container's status code and status, moving it along one poll
+func (s *Server) status(c *container) (string, string) {
+	switch {
+	case c.published:
+		return "PUBLISHED", "Published"
+	case c.polls < c.processing:
+		c.polls++
+		return "IN_PROGRESS", "In Progress"
+	case c.failure != nil:
+		return "ERROR", fmt.Sprintf("Error: %s", c.failure.Message)
+	}
+	return "FINISHED", "Finished: Media has been uploaded and it is ready to be published."
+}
+
+func (s *Server) containerStatus(w http.ResponseWriter, r *http.Request, c *container) {
+	if !s.called(w, ContainerStatus) || !s.authorised(w, r) {
+		return
+	}
+
+	code, status := s.status(c)
+	writeJSON(w, map[string]string{
+		"id":          c.id,
+		"status_code": code,
+		"status":      status,
+	})
+}
+
+func (s *Server) publish(w http.ResponseWriter, r *http.Request, igId string) {
+	if !s.called(w, Publish) || !s.authorised(w, r) {
+		return
+	}
+
+	c, ok := s.containers[r.FormValue("creation_id")]
+	switch {
+	case igId != s.IgId:
+		writeError(w, Failure{Code: 100, Subcode: 33, Message: fmt.Sprintf("Object with ID '%s' does not exist", igId)})
+		return
+	case !ok:
+		writeError(w, Failure{Code: 100, Message: "Invalid creation_id"})
+		return
+	case c.carouselItem:
+		writeError(w, Failure{Code: 100, Message: "Carousel items are published with their carousel"})
+		return
+	case c.published:
+		writeError(w, Failure{Code: 9007, Subcode: 2207008, Message: "The media has already been published"})
+		return
+	case c.polls < c.processing:
+		writeError(w, Failures["not_ready"])
+		return
+	case c.failure != nil:
+		writeError(w, *c.failure)
+		return
+	}
+
+	c.published = true
+	m := &Published{Id: s.id(), ContainerId: c.id, Caption: c.caption, At: time.Now()}
+	if c.imageUrl != "" {
+		m.ImageUrls = []string{c.imageUrl}
+	}
+	for _, id := range c.children {
+		m.ImageUrls = append(m.ImageUrls, s.containers[id].imageUrl)
+	}
+	// Counts that differ between posts, so reports built from them have something to show
+	n := len(s.published) + 1
+	m.Reach, m.Views = 100*n, 130*n
+	m.Likes, m.Comments, m.Saves = 10*n, n, 2*n
+	s.media[m.Id] = m
+	s.published = append(s.published, m)
+
+	writeJSON(w, map[string]string{"id": m.Id})
+}
+
+func (s *Server) insights(w http.ResponseWriter, r *http.Request, m *Published) {
+	if !s.called(w, Insights) || !s.authorised(w, r) {
+		return
+	}
+	// The Graph API rejects requests for metrics it has removed
+	if strings.Contains(r.FormValue("fields"), "impressions") {
+		writeError(w, Failure{Code: 100, Message: "(#100) impressions metric is no longer supported"})
+		return
+	}
+
+	metric := func(name string, value int) map[string]interface{} {
+		return map[string]interface{}{
+			"name":   name,
+			"period": "lifetime",
+			"values": []map[string]int{{"value": value}},
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"id":             m.Id,
+		"like_count":     m.Likes,
+		"comments_count": m.Comments,
+		"insights": map[string]interface{}{
+			"data": []map[string]interface{}{
+				metric("reach", m.Reach),
+				metric("views", m.Views),
+				metric("saved", m.Saves),
+			},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, f Failure) {
+	status := f.StatusCode
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	errType := "OAuthException"
+	if f.Code != 190 && f.Code != 102 {
+		errType = "GraphMethodException"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message":       f.Message,
+			"type":          errType,
+			"code":          f.Code,
+			"error_subcode": f.Subcode,
+			"is_transient":  f.Transient,
+			"fbtrace_id":    "fake",
+		},
+	})
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"strings"
+
+	"github.com/baely/weightloss-tracker/internal/util"
 )
 
 type urlParams = map[string][]string
@@ -38,6 +41,12 @@ type TokenInspect struct {
 		} `json:"metadata"`
 		Scopes []string `json:"scopes"`
 		UserId string   `json:"user_id"`
+		// Error explains why a token isn't valid
+		Error struct {
+			Code    int    `json:"code"`
+			Subcode int    `json:"subcode"`
+			Message string `json:"message"`
+		} `json:"error"`
 	} `json:"data"`
 }
 
@@ -141,17 +150,16 @@ mail@xbd.au
 `
 )
 
-var (
-	appId       = os.Getenv("IG_APP_ID")
-	appSecret   = os.Getenv("IG_SECRET")
-	callbackUri = "https://weight.xbd.au/new-token"
-)
+// MaxCarouselItems is the most images a carousel can hold
+const MaxCarouselItems = 10
 
 func DoReq[T any](method, baseUri string, params urlParams) (T, error) {
-	client := &http.Client{}
 	var t T
 
 	req, err := http.NewRequest(method, baseUri, nil)
+	if err != nil {
+		return t, err
+	}
 	q := req.URL.Query()
 	for key, valList := range params {
 		for _, val := range valList {
@@ -161,10 +169,11 @@ func DoReq[T any](method, baseUri string, params urlParams) (T, error) {
 
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := client.Do(req)
+	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
-		return t, err
+		return t, util.RedactURL(err, baseUri)
 	}
+	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -172,7 +181,7 @@ func DoReq[T any](method, baseUri string, params urlParams) (T, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return t, fmt.Errorf("error from %s, %d: %s", baseUri, resp.StatusCode, body)
+		return t, parseError(baseUri, resp.StatusCode, body)
 	}
 
 	err = json.Unmarshal(body, &t)
@@ -192,7 +201,7 @@ func PostReq[T any](baseUri string, params map[string][]string) (T, error) {
 }
 
 func GetAccessToken() (string, error) {
-	baseUri := "https://graph.facebook.com/oauth/access_token"
+	baseUri := graphBaseUri + "/oauth/access_token"
 	params := urlParams{
 		"client_id":     []string{appId},
 		"client_secret": []string{appSecret},
@@ -208,19 +217,25 @@ func GetAccessToken() (string, error) {
 	return access.AccessToken, nil
 }
 
-func GetUserId(accessToken string) (string, error) {
+// InspectToken returns what debug_token reports about a token. Invalid tokens are reported with
+// IsValid false rather than an error.
+func InspectToken(accessToken string) (TokenInspect, error) {
 	access, err := GetAccessToken()
 	if err != nil {
-		return "", err
+		return TokenInspect{}, err
 	}
 
-	baseUri := "https://graph.facebook.com/debug_token"
+	baseUri := graphBaseUri + "/debug_token"
 	params := urlParams{
 		"input_token":  []string{accessToken},
 		"access_token": []string{access},
 	}
 
-	inspect, err := GetReq[TokenInspect](baseUri, params)
+	return GetReq[TokenInspect](baseUri, params)
+}
+
+func GetUserId(accessToken string) (string, error) {
+	inspect, err := InspectToken(accessToken)
 	if err != nil {
 		return "", err
 	}
@@ -234,7 +249,12 @@ func GetDetails(accessToken string) (string, string, error) {
 		return "", "", err
 	}
 
-	baseUri := fmt.Sprintf("https://graph.facebook.com/v17.0/%s/accounts", userId)
+	return GetPage(userId, accessToken)
+}
+
+// GetPage returns the ID and page token of the configured page among the user's pages
+func GetPage(userId, accessToken string) (string, string, error) {
+	baseUri := graphUri("%s/accounts", userId)
 	params := urlParams{
 		"access_token": []string{accessToken},
 	}
@@ -245,16 +265,19 @@ func GetDetails(accessToken string) (string, string, error) {
 	}
 
 	for _, page := range details.Data {
-		if page.Name == "Blw" {
+		if pageId != "" && page.Id == pageId || pageId == "" && page.Name == pageName {
 			return page.Id, page.AccessToken, nil
 		}
 	}
 
-	return "", "", fmt.Errorf("no page found")
+	if pageId != "" {
+		return "", "", fmt.Errorf("no page found with ID %q", pageId)
+	}
+	return "", "", fmt.Errorf("no page found named %q", pageName)
 }
 
 func CreateContainer(igId, imgAddr, caption, accessToken string) (string, error) {
-	baseUri := fmt.Sprintf("https://graph.facebook.com/v17.0/%s/media", igId)
+	baseUri := graphUri("%s/media", igId)
 	params := urlParams{
 		"image_url":    []string{imgAddr},
 		"caption":      []string{caption},
@@ -269,27 +292,75 @@ func CreateContainer(igId, imgAddr, caption, accessToken string) (string, error)
 	return cr.Id, nil
 }
 
-func PublishContent(igId, containerId, accessToken string) error {
-	baseUri := fmt.Sprintf("https://graph.facebook.com/v17.0/%s/media_publish", igId)
+// CreateCarouselItem creates a container for one image of a carousel
+func CreateCarouselItem(igId, imgAddr, accessToken string) (string, error) {
+	baseUri := graphUri("%s/media", igId)
+	params := urlParams{
+		"image_url":        []string{imgAddr},
+		"is_carousel_item": []string{"true"},
+		"access_token":     []string{accessToken},
+	}
+
+	cr, err := PostReq[ContainerResp](baseUri, params)
+	if err != nil {
+		return "", err
+	}
+
+	return cr.Id, nil
+}
+
+// CreateCarousel creates a carousel container from item containers, in order. It is published
+// with PublishContent like a single image.
+func CreateCarousel(igId string, itemIds []string, caption, accessToken string) (string, error) {
+	if len(itemIds) < 2 || len(itemIds) > MaxCarouselItems {
+		return "", fmt.Errorf("carousel needs 2 to %d items, got %d", MaxCarouselItems, len(itemIds))
+	}
+
+	baseUri := graphUri("%s/media", igId)
+	params := urlParams{
+		"media_type":   []string{"CAROUSEL"},
+		"children":     []string{strings.Join(itemIds, ",")},
+		"caption":      []string{caption},
+		"access_token": []string{accessToken},
+	}
+
+	cr, err := PostReq[ContainerResp](baseUri, params)
+	if err != nil {
+		return "", err
+	}
+
+	return cr.Id, nil
+}
+
+// PublishContent publishes a container and returns the ID of the published media
+func PublishContent(igId, containerId, accessToken string) (string, error) {
+	baseUri := graphUri("%s/media_publish", igId)
 	params := urlParams{
 		"creation_id":  []string{containerId},
 		"access_token": []string{accessToken},
 	}
 
-	_, err := PostReq[interface{}](baseUri, params)
+	media, err := PostReq[ContainerResp](baseUri, params)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return nil
+	return media.Id, nil
 }
 
+// AuthUrl returns the OAuth dialog address that grants a new token
 func AuthUrl() string {
-	return fmt.Sprintf("https://www.facebook.com/v17.0/dialog/oauth?client_id=%s&redirect_uri=%s&state={false=true}&scope=instagram_basic,pages_show_list,business_management,instagram_basic,instagram_content_publish&request_", appId, callbackUri)
+	params := url.Values{
+		"client_id":    []string{appId},
+		"redirect_uri": []string{callbackUri},
+		"state":        []string{"{false=true}"},
+		"scope":        []string{strings.Join(scopes, ",")},
+	}
+	return fmt.Sprintf("%s/%s/dialog/oauth?%s", facebookBaseUri, apiVersion, params.Encode())
 }
 
 func GetToken(code string) (string, error) {
-	baseUri := "https://graph.facebook.com/v17.0/oauth/access_token"
+	baseUri := graphUri("oauth/access_token")
 	params := urlParams{
 		"client_id":     []string{appId},
 		"redirect_uri":  []string{callbackUri},
@@ -306,7 +377,7 @@ func GetToken(code string) (string, error) {
 }
 
 func BusinessAccount(pageId, accessToken string) (string, error) {
-	baseUri := fmt.Sprintf("https://graph.facebook.com/v17.0/%s", pageId)
+	baseUri := graphUri("%s", pageId)
 	params := urlParams{
 		"fields":       []string{"instagram_business_account"},
 		"access_token": []string{accessToken},
@@ -320,8 +391,9 @@ func BusinessAccount(pageId, accessToken string) (string, error) {
 	return account.InstagramBusinessAccount.Id, nil
 }
 
-func GetLongToken(token string) (string, error) {
-	baseUri := "https://graph.facebook.com/v17.0/oauth/access_token"
+// GetLongToken exchanges a token for a long-lived token
+func GetLongToken(token string) (LongToken, error) {
+	baseUri := graphUri("oauth/access_token")
 	params := urlParams{
 		"grant_type":        []string{"fb_exchange_token"},
 		"client_id":         []string{appId},
@@ -329,12 +401,45 @@ func GetLongToken(token string) (string, error) {
 		"fb_exchange_token": []string{token},
 	}
 
-	longToken, err := GetReq[LongToken](baseUri, params)
-	if err != nil {
-		return "", err
+	return GetReq[LongToken](baseUri, params)
+}
+
+// MediaInsights is a published media's engagement
+type MediaInsights struct {
+	Id            string `json:"id"`
+	LikeCount     int    `json:"like_count"`
+	CommentsCount int    `json:"comments_count"`
+	Insights      struct {
+		Data []struct {
+			Name   string `json:"name"`
+			Values []struct {
+				Value int `json:"value"`
+			} `json:"values"`
+		} `json:"data"`
+	} `json:"insights"`
+}
+
+// Metric returns the value of a lifetime insights metric, such as "reach", or 0 if it's missing
+func (m MediaInsights) Metric(name string) int {
+	for _, d := range m.Insights.Data {
+		if d.Name == name && len(d.Values) > 0 {
+			return d.Values[0].Value
+		}
+	}
+	return 0
+}
+
+// GetMediaInsights returns a published media's like and comment counts with its reach, views and
+// saves, in one request. Views replaced the removed impressions metric. Insights need the
+// instagram_manage_insights permission.
+func GetMediaInsights(mediaId, accessToken string) (MediaInsights, error) {
+	baseUri := graphUri("%s", mediaId)
+	params := urlParams{
+		"fields":       []string{"id,like_count,comments_count,insights.metric(reach,views,saved)"},
+		"access_token": []string{accessToken},
 	}
 
-	return longToken.AccessToken, nil
+	return GetReq[MediaInsights](baseUri, params)
 }
 
 func Policy() string {
@@ -0,0 +1,397 @@
+package meta_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta/fakegraph"
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+// fake is the fake Graph API every test in this file runs against, served at fakeUri
+var (
+	fake    *fakegraph.Server
+	fakeUri string
+)
+
+func TestMain(m *testing.M) {
+	fake = fakegraph.New()
+	server := httptest.NewServer(fake)
+	fakeUri = server.URL
+	meta.SetBaseUri(fakeUri)
+	restore := meta.SetDelays(time.Millisecond, time.Millisecond)
+
+	code := m.Run()
+
+	restore()
+	server.Close()
+	os.Exit(code)
+}
+
+// account is the page token and Instagram account found for a new user token
+type account struct {
+	token     string
+	pageToken string
+	igId      string
+}
+
+// setup clears the fake's scripted failures and call counts and finds the account for a new user
+// token, as posting does
+func setup(t *testing.T) account {
+	t.Helper()
+	fake.Reset()
+
+	a := account{token: fake.IssueToken(0)}
+	pageId, pageToken, err := meta.GetDetails(a.token)
+	if err != nil {
+		t.Fatalf("finding page: %v", err)
+	}
+	a.igId, err = meta.BusinessAccount(pageId, pageToken)
+	if err != nil {
+		t.Fatalf("finding Instagram account: %v", err)
+	}
+	a.pageToken = pageToken
+	return a
+}
+
+// TestOAuth follows the dialog's redirect and exchanges the code for a long-lived token
+func TestOAuth(t *testing.T) {
+	fake.Reset()
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(meta.AuthUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := redirect.Query().Get("code")
+	if code == "" {
+		t.Fatalf("no code in redirect %q", redirect)
+	}
+
+	short, err := meta.GetToken(code)
+	if err != nil {
+		t.Fatalf("exchanging code: %v", err)
+	}
+	if _, err = meta.GetToken(code); err == nil {
+		t.Error("code exchanged twice")
+	}
+
+	long, err := meta.GetLongToken(short)
+	if err != nil {
+		t.Fatalf("exchanging for a long-lived token: %v", err)
+	}
+	if long.ExpiresIn <= 0 {
+		t.Errorf("long-lived token expires in %d", long.ExpiresIn)
+	}
+}
+
+func TestInspectToken(t *testing.T) {
+	fake.Reset()
+	token := fake.IssueToken(0)
+
+	inspect, err := meta.InspectToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inspect.Data.IsValid {
+		t.Errorf("token not valid: %s", inspect.Data.Error.Message)
+	}
+	if inspect.Data.UserId != fake.UserId {
+		t.Errorf("user %q, want %q", inspect.Data.UserId, fake.UserId)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	a := setup(t)
+
+	if a.pageToken != fake.PageToken() {
+		t.Errorf("page token %q, want %q", a.pageToken, fake.PageToken())
+	}
+	if a.igId != fake.IgId {
+		t.Errorf("Instagram account %q, want %q", a.igId, fake.IgId)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	a := setup(t)
+
+	containerId, err := meta.CreateContainer(a.igId, "https://example.com/card.jpg", "Day 1", a.pageToken)
+	if err != nil {
+		t.Fatalf("creating container: %v", err)
+	}
+	if err = meta.WaitForContainer(containerId, a.pageToken); err != nil {
+		t.Fatalf("waiting for container: %v", err)
+	}
+	mediaId, err := meta.PublishContent(a.igId, containerId, a.pageToken)
+	if err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+
+	checkPublished(t, mediaId, "Day 1", 1)
+
+	// Publishing the same container again must not post twice
+	if _, err = meta.PublishContent(a.igId, containerId, a.pageToken); err == nil {
+		t.Error("container published twice")
+	}
+}
+
+func TestCarousel(t *testing.T) {
+	a := setup(t)
+
+	var itemIds []string
+	for _, name := range []string{"chart", "day-1", "day-2"} {
+		id, err := meta.CreateCarouselItem(a.igId, fmt.Sprintf("https://example.com/%s.jpg", name), a.pageToken)
+		if err != nil {
+			t.Fatalf("creating carousel item %s: %v", name, err)
+		}
+		itemIds = append(itemIds, id)
+	}
+
+	containerId, err := meta.CreateCarousel(a.igId, itemIds, "Week 1", a.pageToken)
+	if err != nil {
+		t.Fatalf("creating carousel: %v", err)
+	}
+	if err = meta.WaitForContainer(containerId, a.pageToken); err != nil {
+		t.Fatalf("waiting for carousel: %v", err)
+	}
+	mediaId, err := meta.PublishContent(a.igId, containerId, a.pageToken)
+	if err != nil {
+		t.Fatalf("publishing carousel: %v", err)
+	}
+
+	checkPublished(t, mediaId, "Week 1", len(itemIds))
+}
+
+// TestProcessing publishes only once the container has finished processing
+func TestProcessing(t *testing.T) {
+	a := setup(t)
+	fake.SetProcessingPolls(1)
+	defer fake.SetProcessingPolls(0)
+
+	containerId, err := meta.CreateContainer(a.igId, "https://example.com/card.jpg", "Slow", a.pageToken)
+	if err != nil {
+		t.Fatalf("creating container: %v", err)
+	}
+	if _, err = meta.PublishContent(a.igId, containerId, a.pageToken); !meta.Retryable(err) {
+		t.Errorf("publishing an unfinished container returned %v, want a retryable error", err)
+	}
+	if err = meta.WaitForContainer(containerId, a.pageToken); err != nil {
+		t.Fatalf("waiting for container: %v", err)
+	}
+	if calls := fake.Calls(fakegraph.ContainerStatus); calls != 2 {
+		t.Errorf("container status read %d times, want 2", calls)
+	}
+
+	if _, err = meta.PublishContent(a.igId, containerId, a.pageToken); err != nil {
+		t.Errorf("publishing finished container: %v", err)
+	}
+}
+
+// TestRetry retries rate limits and server errors until the request goes through
+func TestRetry(t *testing.T) {
+	a := setup(t)
+	fake.Fail(fakegraph.Media, fakegraph.Failures["rate_limit"], 1)
+	fake.Fail(fakegraph.Media, fakegraph.Failures["server_error"], 1)
+
+	_, err := meta.Retry(func() (string, error) {
+		return meta.CreateContainer(a.igId, "https://example.com/card.jpg", "Retried", a.pageToken)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := fake.Calls(fakegraph.Media); calls != 3 {
+		t.Errorf("container created in %d requests, want 3", calls)
+	}
+}
+
+// TestAuthError doesn't retry a rejected token, and reports it as rejected, but not a missing
+// permission
+func TestAuthError(t *testing.T) {
+	a := setup(t)
+	fake.Fail(fakegraph.Media, fakegraph.Failures["token_expired"], 1)
+
+	_, err := meta.Retry(func() (string, error) {
+		return meta.CreateContainer(a.igId, "https://example.com/card.jpg", "Expired", a.pageToken)
+	})
+	checkClass(t, err, meta.ErrorAuth)
+	if calls := fake.Calls(fakegraph.Media); calls != 1 {
+		t.Errorf("rejected token tried %d times, want 1", calls)
+	}
+	if !meta.TokenRejected(err) {
+		t.Error("expired token not reported as rejected")
+	}
+
+	fake.Fail(fakegraph.Publish, fakegraph.Failures["permission"], 1)
+	_, err = meta.PublishContent(a.igId, "0", a.pageToken)
+	checkClass(t, err, meta.ErrorAuth)
+	if meta.TokenRejected(err) {
+		t.Error("missing permission reported as a rejected token")
+	}
+}
+
+func TestMediaError(t *testing.T) {
+	a := setup(t)
+	fake.Fail(fakegraph.Media, fakegraph.Failures["bad_image"], 1)
+
+	_, err := meta.CreateContainer(a.igId, "https://example.com/missing.jpg", "Missing", a.pageToken)
+	checkClass(t, err, meta.ErrorMedia)
+}
+
+// TestContainerError stops waiting on a container that failed processing
+func TestContainerError(t *testing.T) {
+	a := setup(t)
+	fake.Fail(fakegraph.Processing, fakegraph.Failures["bad_image"], 1)
+
+	containerId, err := meta.CreateContainer(a.igId, "https://example.com/huge.jpg", "Huge", a.pageToken)
+	if err != nil {
+		t.Fatalf("creating container: %v", err)
+	}
+	err = meta.WaitForContainer(containerId, a.pageToken)
+	checkClass(t, err, meta.ErrorMedia)
+}
+
+func TestMediaInsights(t *testing.T) {
+	a := setup(t)
+
+	containerId, err := meta.CreateContainer(a.igId, "https://example.com/card.jpg", "Insights", a.pageToken)
+	if err != nil {
+		t.Fatalf("creating container: %v", err)
+	}
+	mediaId, err := meta.PublishContent(a.igId, containerId, a.pageToken)
+	if err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+
+	m, err := meta.GetMediaInsights(mediaId, a.pageToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := published(mediaId)
+	if !ok {
+		t.Fatalf("media %s not published", mediaId)
+	}
+	got := []int{m.Metric("reach"), m.Metric("views"), m.Metric("saved"), m.LikeCount, m.CommentsCount}
+	want := []int{p.Reach, p.Views, p.Saves, p.Likes, p.Comments}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("reach, views, saves, likes and comments %v, want %v", got, want)
+	}
+}
+
+// TestRevoked reports a revoked token as invalid rather than failing to inspect it, and rejects
+// requests made with it
+func TestRevoked(t *testing.T) {
+	fake.Reset()
+	token := fake.IssueToken(0)
+	fake.Revoke(token)
+
+	inspect, err := meta.InspectToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspect.Data.IsValid || inspect.Data.Error.Code != 190 {
+		t.Errorf("revoked token valid %t with error code %d", inspect.Data.IsValid, inspect.Data.Error.Code)
+	}
+
+	_, _, err = meta.GetPage(fake.UserId, token)
+	if !meta.TokenRejected(err) {
+		t.Errorf("page request with a revoked token returned %v, want it rejected", err)
+	}
+}
+
+// TestNetworkError leaves the access token out of the error for a request that gets no response,
+// and retries it
+func TestNetworkError(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	meta.SetBaseUri(closed.URL)
+	defer meta.SetBaseUri(fakeUri)
+
+	_, err := meta.CreateContainer("1", "https://example.com/card.jpg", "Offline", "secret-page-token")
+	if err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "secret-page-token") {
+		t.Errorf("error includes the access token: %v", err)
+	}
+	if !meta.Retryable(err) {
+		t.Errorf("network error %v not retryable", err)
+	}
+}
+
+// TestTimeout gives up on a request that gets no response, with an error that is retried
+func TestTimeout(t *testing.T) {
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hung.Close()
+	meta.SetBaseUri(hung.URL)
+	defer meta.SetBaseUri(fakeUri)
+
+	client := util.HTTPClient
+	util.HTTPClient = &http.Client{Timeout: 10 * time.Millisecond}
+	defer func() { util.HTTPClient = client }()
+
+	_, err := meta.GetContainerStatus("1", "page-token")
+	if !meta.Retryable(err) {
+		t.Errorf("request that timed out returned %v, want a retryable error", err)
+	}
+}
+
+// TestMalformedBaseUri returns an error for a GRAPH_BASE_URL that isn't a URL
+func TestMalformedBaseUri(t *testing.T) {
+	meta.SetBaseUri("http://[::1")
+	defer meta.SetBaseUri(fakeUri)
+
+	if _, err := meta.GetContainerStatus("1", "page-token"); err == nil {
+		t.Error("request to a malformed address succeeded")
+	}
+}
+
+func published(mediaId string) (fakegraph.Published, bool) {
+	for _, p := range fake.Published() {
+		if p.Id == mediaId {
+			return p, true
+		}
+	}
+	return fakegraph.Published{}, false
+}
+
+// checkPublished checks the fake has the media with its caption and images
+func checkPublished(t *testing.T, mediaId, caption string, images int) {
+	t.Helper()
+
+	p, ok := published(mediaId)
+	if !ok {
+		t.Fatalf("media %s not published", mediaId)
+	}
+	if p.Caption != caption || len(p.ImageUrls) != images {
+		t.Errorf("published %q with %d images, want %q with %d", p.Caption, len(p.ImageUrls), caption, images)
+	}
+}
+
+func checkClass(t *testing.T, err error, want meta.ErrorClass) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("no error, want a %s error", want)
+	}
+	if got := meta.Class(err); got != want {
+		t.Errorf("%s error, want %s: %v", got, want, err)
+	}
+}
@@ -0,0 +1,98 @@
+package meta
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	maxAttempts = 5
+	maxBackoff  = 30 * time.Second
+
+	containerTimeout = 2 * time.Minute
+)
+
+// Waits between attempts, variables so tests can shorten them
+var (
+	retryBackoff          = 2 * time.Second
+	containerPollInterval = 3 * time.Second
+)
+
+// Container status codes
+const (
+	ContainerFinished   = "FINISHED"
+	ContainerInProgress = "IN_PROGRESS"
+	ContainerError      = "ERROR"
+	ContainerExpired    = "EXPIRED"
+	ContainerPublished  = "PUBLISHED"
+)
+
+type ContainerStatus struct {
+	Id         string `json:"id"`
+	StatusCode string `json:"status_code"`
+	Status     string `json:"status"`
+}
+
+// Retry calls fn until it succeeds, returns an error that isn't retryable, or has been tried
+// maxAttempts times. The wait between attempts doubles each time up to maxBackoff.
+func Retry[T any](fn func() (T, error)) (T, error) {
+	backoff := retryBackoff
+
+	var t T
+	var err error
+	for attempt := 1; ; attempt++ {
+		t, err = fn()
+		if err == nil || !Retryable(err) || attempt == maxAttempts {
+			return t, err
+		}
+
+		fmt.Printf("retrying in %s after attempt %d: %v\n", backoff, attempt, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// GetContainerStatus returns the processing status of a media container
+func GetContainerStatus(containerId, accessToken string) (ContainerStatus, error) {
+	baseUri := graphUri("%s", containerId)
+	params := urlParams{
+		"fields":       []string{"id,status_code,status"},
+		"access_token": []string{accessToken},
+	}
+
+	return GetReq[ContainerStatus](baseUri, params)
+}
+
+// WaitForContainer polls a container until it is ready to publish. Containers that fail or expire
+// return a media error, and containers still in progress after containerTimeout return a
+// transient error.
+func WaitForContainer(containerId, accessToken string) error {
+	deadline := time.Now().Add(containerTimeout)
+	uri := graphUri("%s", containerId)
+
+	for {
+		status, err := Retry(func() (ContainerStatus, error) {
+			return GetContainerStatus(containerId, accessToken)
+		})
+		if err != nil {
+			return err
+		}
+
+		switch status.StatusCode {
+		case ContainerFinished:
+			return nil
+		case ContainerError, ContainerExpired:
+			return &Error{Uri: uri, Message: fmt.Sprintf("container %s: %s", status.StatusCode, status.Status), Class: ErrorMedia}
+		case ContainerPublished:
+			return &Error{Uri: uri, Message: "container already published", Class: ErrorPermanent}
+		}
+
+		if time.Now().After(deadline) {
+			return &Error{Uri: uri, Message: fmt.Sprintf("container still %s after %s", status.StatusCode, containerTimeout), Class: ErrorTransient}
+		}
+		time.Sleep(containerPollInterval)
+	}
+}
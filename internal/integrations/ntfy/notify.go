@@ -13,6 +13,12 @@ const (
 
 var target, _ = url.JoinPath(baseUri, topic)
 
+// SetBaseUri sends notifications to the topic on another server, such as a fake started by a
+// test
+func SetBaseUri(uri string) {
+	target, _ = url.JoinPath(uri, topic)
+}
+
 func Notify(body string) error {
 	client := &http.Client{}
 
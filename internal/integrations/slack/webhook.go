@@ -0,0 +1,59 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+var webhookUrl = os.Getenv("SLACK_WEBHOOK_URL")
+
+type block struct {
+	Type     string `json:"type"`
+	Text     *text  `json:"text,omitempty"`
+	ImageUrl string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Configured reports whether a webhook is set
+func Configured() bool {
+	return webhookUrl != ""
+}
+
+// PostImage posts a message with a publicly reachable image. Incoming webhooks don't return
+// the message, so there is no ID.
+func PostImage(imageUrl, caption, altText string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"text": caption,
+		"blocks": []block{
+			{Type: "section", Text: &text{Type: "mrkdwn", Text: caption}},
+			{Type: "image", ImageUrl: imageUrl, AltText: altText},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := util.HTTPClient.Post(webhookUrl, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return util.RedactURL(err, "slack webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error from slack webhook, %d: %s", resp.StatusCode, b)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const baseUri = "https://api.telegram.org"
+
+var (
+	botToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+	// channel is the channel username, such as "@weightlog", or its numeric chat ID
+	channel = os.Getenv("TELEGRAM_CHANNEL")
+)
+
+type response struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+	Result      struct {
+		MessageId int `json:"message_id"`
+	} `json:"result"`
+}
+
+// Configured reports whether a bot token and channel are set
+func Configured() bool {
+	return botToken != "" && channel != ""
+}
+
+// SendPhoto posts a publicly reachable image to the channel and returns the message ID
+func SendPhoto(photoUrl, caption string) (string, error) {
+	form := url.Values{
+		"chat_id": []string{channel},
+		"photo":   []string{photoUrl},
+		"caption": []string{caption},
+	}
+
+	resp, err := util.HTTPClient.PostForm(fmt.Sprintf("%s/bot%s/sendPhoto", baseUri, botToken), form)
+	if err != nil {
+		return "", util.RedactURL(err, baseUri+"/sendPhoto")
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var r response
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return "", fmt.Errorf("error from sendPhoto, %d: %s", resp.StatusCode, b)
+	}
+	if !r.Ok {
+		return "", fmt.Errorf("error from sendPhoto, %d: %s", resp.StatusCode, r.Description)
+	}
+
+	return strconv.Itoa(r.Result.MessageId), nil
+}
@@ -0,0 +1,103 @@
+package publish
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+// carouselPublisher is a destination that can publish several images as one post
+type carouselPublisher interface {
+	Publisher
+	PublishCarousel(caption string, posts []Post) (Receipt, error)
+	maxCarouselItems() int
+}
+
+// Backfill publishes missed posts, oldest first, to every publisher that doesn't have them yet.
+// Publishers run at once but each gets the posts in order. With carousel set, destinations that
+// support carousels get the posts combined into as few carousels as they allow, captioned with
+// the date range; the rest get individual posts.
+func Backfill(publishers []Publisher, posts []Post, carousel bool) []Result {
+	results := make([][]Result, len(publishers))
+
+	var wg sync.WaitGroup
+	for i, p := range publishers {
+		wg.Add(1)
+		go func(i int, p Publisher) {
+			defer wg.Done()
+			if c, ok := p.(carouselPublisher); ok && carousel {
+				results[i] = backfillCarousel(c, posts)
+				return
+			}
+			for _, post := range posts {
+				results[i] = append(results[i], publishOnce(p, post, false))
+			}
+		}(i, p)
+	}
+	wg.Wait()
+
+	var all []Result
+	for _, r := range results {
+		all = append(all, r...)
+	}
+	return all
+}
+
+func backfillCarousel(p carouselPublisher, posts []Post) []Result {
+	var results []Result
+
+	var missing []Post
+	var records []database.PostDocument
+	for _, post := range posts {
+		record, result, ok := claim(p, post, false)
+		if !ok {
+			results = append(results, result)
+			continue
+		}
+		missing = append(missing, post)
+		records = append(records, record)
+	}
+
+	for len(missing) > 0 {
+		n := len(missing)
+		if n > p.maxCarouselItems() {
+			n = p.maxCarouselItems()
+		}
+
+		// The later carousels' posts stay claimed while this one publishes
+		stop := heartbeat(records[n:]...)
+		results = append(results, publishCarousel(p, missing[:n], records[:n])...)
+		stop()
+		missing, records = missing[n:], records[n:]
+	}
+
+	return results
+}
+
+// publishCarousel publishes the claimed posts as one carousel, recording each date against it
+func publishCarousel(p carouselPublisher, posts []Post, records []database.PostDocument) []Result {
+	var results []Result
+	if len(posts) == 0 {
+		return results
+	}
+
+	stop := heartbeat(records...)
+	var receipt Receipt
+	var err error
+	switch len(posts) {
+	case 1:
+		// A carousel needs at least two images
+		receipt, err = p.Publish(posts[0].captionedFor(p.Name()))
+	default:
+		caption := fmt.Sprintf("%s to %s", posts[0].Date, posts[len(posts)-1].Date)
+		receipt, err = p.PublishCarousel(caption, posts)
+	}
+	stop()
+
+	for _, record := range records {
+		results = append(results, finish(record, receipt, err))
+	}
+
+	return results
+}
@@ -0,0 +1,37 @@
+package publish
+
+import (
+	"fmt"
+
+	"github.com/baely/weightloss-tracker/internal/integrations/bluesky"
+)
+
+// Bluesky publishes a post with the image embedded
+type Bluesky struct{}
+
+func (Bluesky) Name() string {
+	return "bluesky"
+}
+
+func (Bluesky) configured() bool {
+	return bluesky.Configured()
+}
+
+func (Bluesky) Publish(post Post) (Receipt, error) {
+	session, err := bluesky.CreateSession()
+	if err != nil {
+		return Receipt{}, fmt.Errorf("error creating session: %v", err)
+	}
+
+	blob, err := bluesky.UploadBlob(session, post.Image, post.ContentType)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("error uploading image: %v", err)
+	}
+
+	record, err := bluesky.CreatePost(session, post.Caption, blob, post.AltText)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("error creating post: %v", err)
+	}
+
+	return Receipt{Id: record.Uri}, nil
+}
@@ -0,0 +1,146 @@
+package publish
+
+import (
+	"fmt"
+
+	"github.com/baely/weightloss-tracker/internal/auth"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+	"github.com/baely/weightloss-tracker/internal/integrations/ntfy"
+)
+
+// Instagram publishes to the Instagram business account linked to the Facebook page
+type Instagram struct{}
+
+func (Instagram) Name() string {
+	return "instagram"
+}
+
+func (Instagram) Publish(post Post) (Receipt, error) {
+	return withAccount(func(a auth.Account) (Receipt, error) {
+		containerId, err := meta.Retry(func() (string, error) {
+			return meta.CreateContainer(a.IgId, post.ImageUrl, post.Caption, a.PageToken)
+		})
+		if err != nil {
+			notifyPermanent("creating container", err)
+			return Receipt{}, fmt.Errorf("error creating container: %w", err)
+		}
+
+		return publishContainer(a, containerId)
+	})
+}
+
+// PublishCarousel publishes the posts' images as one carousel, in order
+func (Instagram) PublishCarousel(caption string, posts []Post) (Receipt, error) {
+	return withAccount(func(a auth.Account) (Receipt, error) {
+		itemIds := make([]string, len(posts))
+		for i, post := range posts {
+			var err error
+			itemIds[i], err = meta.Retry(func() (string, error) {
+				return meta.CreateCarouselItem(a.IgId, post.ImageUrl, a.PageToken)
+			})
+			if err != nil {
+				notifyPermanent("creating carousel item", err)
+				return Receipt{}, fmt.Errorf("error creating carousel item for %s: %w", post.Date, err)
+			}
+		}
+
+		// Items must finish processing before the carousel can be created from them
+		for i, itemId := range itemIds {
+			err := meta.WaitForContainer(itemId, a.PageToken)
+			if err != nil {
+				notifyPermanent("processing carousel item", err)
+				return Receipt{}, fmt.Errorf("error processing carousel item for %s: %w", posts[i].Date, err)
+			}
+		}
+
+		containerId, err := meta.Retry(func() (string, error) {
+			return meta.CreateCarousel(a.IgId, itemIds, caption, a.PageToken)
+		})
+		if err != nil {
+			notifyPermanent("creating carousel", err)
+			return Receipt{}, fmt.Errorf("error creating carousel: %w", err)
+		}
+
+		return publishContainer(a, containerId)
+	})
+}
+
+func (Instagram) maxCarouselItems() int {
+	return meta.MaxCarouselItems
+}
+
+// withAccount posts with the account cached with the token. When the Graph API rejects the cached
+// page token the cache is cleared and the post tried once more with the account found again. A
+// token that is still rejected is marked invalid.
+func withAccount(post func(a auth.Account) (Receipt, error)) (Receipt, error) {
+	a, cached, err := auth.GetAccount()
+	if err != nil {
+		accountFailed(err)
+		return Receipt{}, err
+	}
+
+	receipt, err := post(a)
+	if cached && receipt.Id == "" && meta.TokenRejected(err) {
+		fmt.Println("cached page token rejected, finding account again:", err)
+		auth.ForgetAccount()
+
+		a, _, err = auth.GetAccount()
+		if err != nil {
+			accountFailed(err)
+			return Receipt{}, err
+		}
+		receipt, err = post(a)
+	}
+
+	if meta.TokenRejected(err) {
+		auth.Invalidate(err)
+	}
+	return receipt, err
+}
+
+func accountFailed(err error) {
+	if meta.TokenRejected(err) {
+		auth.Invalidate(err)
+		return
+	}
+	notifyPermanent("finding account", err)
+}
+
+// publishContainer waits for a container to finish processing, then publishes it
+func publishContainer(a auth.Account, containerId string) (Receipt, error) {
+	// Publishing before the container has finished processing fails
+	err := meta.WaitForContainer(containerId, a.PageToken)
+	if err != nil {
+		notifyPermanent("processing container", err)
+		return Receipt{ContainerId: containerId}, fmt.Errorf("error processing container: %w", err)
+	}
+
+	mediaId, err := meta.Retry(func() (string, error) {
+		return meta.PublishContent(a.IgId, containerId, a.PageToken)
+	})
+	if err != nil {
+		notifyPermanent("publishing content", err)
+		return Receipt{ContainerId: containerId}, fmt.Errorf("error publishing content: %w", err)
+	}
+
+	return Receipt{ContainerId: containerId, Id: mediaId}, nil
+}
+
+// notifyPermanent sends a notification for errors that retrying won't fix. Transient errors that
+// outlasted the retries are left to the scheduler's next run.
+func notifyPermanent(step string, err error) {
+	switch meta.Class(err) {
+	case meta.ErrorTransient:
+		return
+	case meta.ErrorAuth:
+		if meta.TokenRejected(err) {
+			// withAccount decides whether the token itself is invalid
+			return
+		}
+		_ = ntfy.Notify(fmt.Sprintf("Instagram token rejected while %s, reauthorise at %s\n%s", step, meta.AuthUrl(), err))
+	case meta.ErrorMedia:
+		_ = ntfy.Notify(fmt.Sprintf("Instagram couldn't use the image while %s:\n%s", step, err))
+	default:
+		_ = ntfy.Notify(fmt.Sprintf("Error %s on Instagram:\n%s", step, err))
+	}
+}
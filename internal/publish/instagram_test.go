@@ -0,0 +1,280 @@
+package publish
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta"
+	"github.com/baely/weightloss-tracker/internal/integrations/meta/fakegraph"
+	"github.com/baely/weightloss-tracker/internal/integrations/ntfy"
+)
+
+// fake is the fake Graph API posts go to, and notices collects the notifications sent
+var (
+	fake    *fakegraph.Server
+	notices = &noticeLog{}
+)
+
+// noticeLog records notifications sent to the fake ntfy server
+type noticeLog struct {
+	sync.Mutex
+	bodies []string
+}
+
+func (n *noticeLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+
+	n.Lock()
+	defer n.Unlock()
+	n.bodies = append(n.bodies, string(b))
+}
+
+func (n *noticeLog) sent() []string {
+	n.Lock()
+	defer n.Unlock()
+	return append([]string(nil), n.bodies...)
+}
+
+func (n *noticeLog) reset() {
+	n.Lock()
+	defer n.Unlock()
+	n.bodies = nil
+}
+
+func TestMain(m *testing.M) {
+	fake = fakegraph.New()
+	graph := httptest.NewServer(fake)
+	meta.SetBaseUri(graph.URL)
+
+	notifications := httptest.NewServer(notices)
+	ntfy.SetBaseUri(notifications.URL)
+
+	code := m.Run()
+
+	graph.Close()
+	notifications.Close()
+	os.Exit(code)
+}
+
+// setup keeps the post log and token in memory, starting empty, and stores a new user token. With
+// pageToken set the account is cached with that page token, as if found by an earlier post.
+func setup(t *testing.T, pageToken string) database.TokenDocument {
+	t.Helper()
+
+	t.Cleanup(database.UseMemory())
+	fake.Reset()
+	notices.reset()
+
+	token := database.TokenDocument{Token: fake.IssueToken(0), UserId: fake.UserId, Valid: true}
+	if pageToken != "" {
+		token.PageId, token.PageToken, token.IgId = fake.PageId, pageToken, fake.IgId
+	}
+	if err := token.InsertOrUpdate(); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func storedToken(t *testing.T) database.TokenDocument {
+	t.Helper()
+
+	token, err := database.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func dailyPost(date string) Post {
+	return Post{
+		Date:     date,
+		Kind:     KindDaily,
+		Caption:  date,
+		Captions: map[string]string{"instagram": "Day " + date},
+		ImageUrl: "https://example.com/" + date + ".jpg",
+	}
+}
+
+func TestInstagramPublish(t *testing.T) {
+	setup(t, fake.PageToken())
+
+	receipt, err := Instagram{}.Publish(Post{Caption: "Day 1", ImageUrl: "https://example.com/card.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	published := fake.Published()
+	if len(published) == 0 || published[len(published)-1].Id != receipt.Id {
+		t.Fatalf("media %s not published", receipt.Id)
+	}
+	if got := published[len(published)-1].Caption; got != "Day 1" {
+		t.Errorf("published with caption %q, want %q", got, "Day 1")
+	}
+	if calls := fake.Calls(fakegraph.Accounts); calls != 0 {
+		t.Errorf("cached account looked up %d times", calls)
+	}
+	if sent := notices.sent(); len(sent) != 0 {
+		t.Errorf("notifications sent: %q", sent)
+	}
+}
+
+// TestWithAccountFindsAccount finds and caches the account when none is cached
+func TestWithAccountFindsAccount(t *testing.T) {
+	setup(t, "")
+
+	if _, err := (Instagram{}).Publish(dailyPost("2023-05-01")); err != nil {
+		t.Fatal(err)
+	}
+
+	token := storedToken(t)
+	if token.IgId != fake.IgId || token.PageToken != fake.PageToken() {
+		t.Errorf("cached account %q with page token %q, want %q with %q", token.IgId, token.PageToken, fake.IgId, fake.PageToken())
+	}
+}
+
+// TestWithAccountStalePageToken clears a cached page token the Graph API rejects, finds the
+// account again and retries
+func TestWithAccountStalePageToken(t *testing.T) {
+	setup(t, "stale-page-token")
+
+	receipt, err := Instagram{}.Publish(dailyPost("2023-05-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Id == "" {
+		t.Error("no media ID after retrying")
+	}
+	if calls := fake.Calls(fakegraph.Media); calls != 2 {
+		t.Errorf("container created in %d requests, want 2", calls)
+	}
+
+	token := storedToken(t)
+	if !token.Valid {
+		t.Error("token marked invalid after its page token was replaced")
+	}
+	if token.PageToken != fake.PageToken() {
+		t.Errorf("cached page token %q, want %q", token.PageToken, fake.PageToken())
+	}
+	if sent := notices.sent(); len(sent) != 0 {
+		t.Errorf("notifications sent: %q", sent)
+	}
+}
+
+// TestWithAccountInvalidates marks the token invalid when it is still rejected after finding the
+// account again, notifying once however many posts fail
+func TestWithAccountInvalidates(t *testing.T) {
+	token := setup(t, "stale-page-token")
+	fake.Revoke(token.Token)
+
+	_, err := Instagram{}.Publish(dailyPost("2023-05-01"))
+	if !meta.TokenRejected(err) {
+		t.Fatalf("publishing with a revoked token returned %v, want it rejected", err)
+	}
+
+	stored := storedToken(t)
+	if stored.Valid {
+		t.Error("revoked token still marked valid")
+	}
+	if stored.PageToken != "" || stored.IgId != "" {
+		t.Errorf("account still cached: %q with page token %q", stored.IgId, stored.PageToken)
+	}
+
+	if _, err = (Instagram{}).Publish(dailyPost("2023-05-02")); !meta.TokenRejected(err) {
+		t.Fatalf("publishing again returned %v, want it rejected", err)
+	}
+
+	sent := notices.sent()
+	if len(sent) != 1 || !strings.Contains(sent[0], "token is invalid") {
+		t.Errorf("notifications %q, want one that the token is invalid", sent)
+	}
+}
+
+func TestNotifyPermanent(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint fakegraph.Endpoint
+		failure  string
+		// want is in the notification sent
+		want string
+	}{
+		{"bad image", fakegraph.Media, "bad_image", "couldn't use the image while creating container"},
+		{"missing permission", fakegraph.Publish, "permission", "token rejected while publishing content"},
+		{"unknown", fakegraph.Publish, "invalid", "Error publishing content on Instagram"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setup(t, fake.PageToken())
+			fake.Fail(tt.endpoint, fakegraph.Failures[tt.failure], 1)
+
+			if _, err := (Instagram{}).Publish(dailyPost("2023-05-01")); err == nil {
+				t.Fatal("publishing succeeded")
+			}
+
+			sent := notices.sent()
+			if len(sent) != 1 || !strings.Contains(sent[0], tt.want) {
+				t.Errorf("notifications %q, want one containing %q", sent, tt.want)
+			}
+		})
+	}
+
+	// Transient errors are left to the next run, and rejected tokens to withAccount
+	notices.reset()
+	notifyPermanent("creating container", &meta.Error{Message: "rate limited", Class: meta.ErrorTransient})
+	notifyPermanent("creating container", &meta.Error{Message: "expired", Code: 190, Class: meta.ErrorAuth})
+	if sent := notices.sent(); len(sent) != 0 {
+		t.Errorf("notifications sent: %q", sent)
+	}
+}
+
+// TestPublishOnce publishes a post once, skips it after unless forced, and skips a post another
+// attempt is publishing
+func TestPublishOnce(t *testing.T) {
+	setup(t, fake.PageToken())
+	post := dailyPost("2023-05-01")
+
+	first := publishOnce(Instagram{}, post, false)
+	if first.Status != database.PostPublished || first.Id == "" {
+		t.Fatalf("first attempt %+v, want it published", first)
+	}
+
+	again := publishOnce(Instagram{}, post, false)
+	if again.Status != StatusSkipped || again.Id != first.Id {
+		t.Errorf("second attempt %+v, want it skipped with media %s", again, first.Id)
+	}
+
+	forced := publishOnce(Instagram{}, post, true)
+	if forced.Status != database.PostPublished || forced.Id == first.Id {
+		t.Errorf("forced attempt %+v, want it published again", forced)
+	}
+	if calls := fake.Calls(fakegraph.Publish); calls != 2 {
+		t.Errorf("published %d times, want 2", calls)
+	}
+
+	logged, found, err := database.GetPost(post.Date, post.Kind, Instagram{}.Name())
+	if err != nil || !found {
+		t.Fatalf("post not logged: %v", err)
+	}
+	if logged.Status != database.PostPublished || logged.MediaId != forced.Id || logged.PublishedAt.IsZero() {
+		t.Errorf("logged %+v, want published as %s", logged, forced.Id)
+	}
+
+	pending := dailyPost("2023-05-02")
+	_, claimed, err := database.ClaimPost(database.PostDocument{Date: pending.Date, Kind: pending.Kind, Destination: Instagram{}.Name(), Status: database.PostPending, UpdatedAt: time.Now()}, false, pendingTimeout)
+	if err != nil || !claimed {
+		t.Fatalf("claiming post: %v", err)
+	}
+	if r := publishOnce(Instagram{}, pending, false); r.Status != StatusSkipped {
+		t.Errorf("attempt while another is publishing %+v, want it skipped", r)
+	}
+	if calls := fake.Calls(fakegraph.Publish); calls != 2 {
+		t.Errorf("published %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,38 @@
+package publish
+
+import (
+	"fmt"
+
+	"github.com/baely/weightloss-tracker/internal/integrations/mastodon"
+	"github.com/baely/weightloss-tracker/internal/util/image"
+)
+
+// Mastodon publishes a status with the image attached
+type Mastodon struct{}
+
+func (Mastodon) Name() string {
+	return "mastodon"
+}
+
+func (Mastodon) configured() bool {
+	return mastodon.Configured()
+}
+
+func (Mastodon) Publish(post Post) (Receipt, error) {
+	format, ok := image.FormatByContentType(post.ContentType)
+	if !ok {
+		return Receipt{}, fmt.Errorf("unsupported image type %q", post.ContentType)
+	}
+
+	mediaId, err := mastodon.UploadMedia(post.Image, post.Date+"."+format.Extension(), post.ContentType, post.AltText)
+	if err != nil {
+		return Receipt{}, fmt.Errorf("error uploading media: %v", err)
+	}
+
+	status, err := mastodon.PostStatus(post.Caption, mediaId)
+	if err != nil {
+		return Receipt{ContainerId: mediaId}, fmt.Errorf("error posting status: %v", err)
+	}
+
+	return Receipt{ContainerId: mediaId, Id: status.Id}, nil
+}
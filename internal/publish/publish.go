@@ -0,0 +1,265 @@
+package publish
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	KindDaily  = "daily"
+	KindWeekly = "weekly"
+
+	// pendingTimeout is how long a pending post blocks another attempt. Posts being published
+	// are refreshed well within it, so a post still pending after this was interrupted.
+	pendingTimeout = 15 * time.Minute
+)
+
+// heartbeatInterval is how often the records of posts being published are refreshed, a variable
+// so tests can shorten it
+var heartbeatInterval = pendingTimeout / 3
+
+// Post is an image post for a day
+type Post struct {
+	Date string
+	// Kind is the kind of post, such as KindDaily. Each kind is posted once per date.
+	Kind    string
+	Caption string
+	// Captions are captions fitted to each destination, by name. Destinations without one use
+	// Caption.
+	Captions map[string]string
+	// ImageUrl is the public address of the image, for destinations that fetch it themselves
+	ImageUrl string
+	// Image is the encoded image, for destinations that need it uploaded
+	Image       []byte
+	ContentType string
+	AltText     string
+	// Carousel is the images, in order, that destinations supporting carousels post instead of
+	// Image. Other destinations post Image alone.
+	Carousel []Post
+}
+
+// captionedFor returns the post with its caption for a destination
+func (p Post) captionedFor(destination string) Post {
+	if c, ok := p.Captions[destination]; ok {
+		p.Caption = c
+	}
+	return p
+}
+
+// Receipt identifies what a destination created for a post
+type Receipt struct {
+	// ContainerId is the upload staged before publishing, on destinations that stage one
+	ContainerId string
+	// Id is the published post, on destinations that return one
+	Id string
+}
+
+// Publisher publishes posts to one destination
+type Publisher interface {
+	// Name is the destination, such as "instagram"
+	Name() string
+	// Publish publishes the post. On error the receipt holds whatever was created before failing.
+	Publish(post Post) (Receipt, error)
+}
+
+// Result is the outcome of publishing to one destination
+type Result struct {
+	Destination string `json:"destination"`
+	Date        string `json:"date"`
+	// Status is a database post status, or skipped when the post was already made
+	Status      string `json:"status"`
+	ContainerId string `json:"containerId,omitempty"`
+	Id          string `json:"id,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+const StatusSkipped = "skipped"
+
+// Configured returns a publisher for every destination with credentials configured. Instagram's
+// token lives in Firestore so it is always included.
+func Configured() []Publisher {
+	publishers := []Publisher{Instagram{}}
+	for _, p := range []configurable{Mastodon{}, Bluesky{}, Telegram{}, Discord{}, Slack{}} {
+		if p.configured() {
+			publishers = append(publishers, p)
+		}
+	}
+	return publishers
+}
+
+type configurable interface {
+	Publisher
+	configured() bool
+}
+
+// PublishAll publishes the post to every publisher at once and returns their results in the same
+// order. One destination failing doesn't stop the others. Each attempt is recorded in the post
+// log, and destinations that already have the post are skipped unless force is set.
+func PublishAll(publishers []Publisher, post Post, force bool) []Result {
+	results := make([]Result, len(publishers))
+
+	var wg sync.WaitGroup
+	for i, p := range publishers {
+		wg.Add(1)
+		go func(i int, p Publisher) {
+			defer wg.Done()
+			results[i] = publishOnce(p, post, force)
+		}(i, p)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Unpublished returns the publishers that don't have a post yet. A post log that can't be read
+// counts as unpublished, leaving publishing to report the error.
+func Unpublished(publishers []Publisher, date, kind string) []Publisher {
+	var missing []Publisher
+	for _, p := range publishers {
+		existing, found, err := database.GetPost(date, kind, p.Name())
+		if err != nil || !found || existing.Status != database.PostPublished {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// Carousels reports whether any of the publishers posts carousels
+func Carousels(publishers []Publisher) bool {
+	for _, p := range publishers {
+		if _, ok := p.(carouselPublisher); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func publishOnce(p Publisher, post Post, force bool) Result {
+	record, result, ok := claim(p, post, force)
+	if !ok {
+		return result
+	}
+
+	post = post.captionedFor(p.Name())
+	stop := heartbeat(record)
+	var receipt Receipt
+	var err error
+	if c, ok := p.(carouselPublisher); ok && len(post.Carousel) > 1 {
+		items := post.Carousel
+		if len(items) > c.maxCarouselItems() {
+			items = items[:c.maxCarouselItems()]
+		}
+		receipt, err = c.PublishCarousel(post.Caption, items)
+	} else {
+		receipt, err = p.Publish(post)
+	}
+	stop()
+	return finish(record, receipt, err)
+}
+
+// heartbeat refreshes the pending records' UpdatedAt until the returned function is called, so an
+// attempt still publishing, such as a carousel waiting on each of its containers, isn't taken for
+// an interrupted one after pendingTimeout
+func heartbeat(records ...database.PostDocument) func() {
+	if len(records) == 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				for _, record := range records {
+					record.UpdatedAt = time.Now()
+					if err := record.InsertOrUpdate(); err != nil {
+						fmt.Printf("error refreshing %s post log for %s: %v\n", record.Destination, record.Date, err)
+					}
+				}
+			}
+		}
+	}()
+
+	// Waiting for the goroutine means a refresh can't land after the outcome is recorded
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// claim records in the post log that a post is about to be published, returning false with the
+// result to report when the destination already has the post or is publishing it now. The log is
+// read and written in one transaction, so of two overlapping attempts only one publishes.
+func claim(p Publisher, post Post, force bool) (database.PostDocument, Result, bool) {
+	result := Result{Destination: p.Name(), Date: post.Date}
+	record := database.PostDocument{
+		Date:        post.Date,
+		Kind:        post.Kind,
+		Destination: p.Name(),
+		Status:      database.PostPending,
+		UpdatedAt:   time.Now(),
+	}
+
+	// Without the log there's no telling whether this would be a duplicate, so don't risk it
+	existing, claimed, err := database.ClaimPost(record, force, pendingTimeout)
+	if err != nil {
+		result.Status = database.PostFailed
+		result.Error = fmt.Sprintf("error writing post log: %v", err)
+		return record, result, false
+	}
+	if !claimed {
+		result.Status = StatusSkipped
+		if existing.Status == database.PostPublished {
+			result.ContainerId, result.Id = existing.ContainerId, existing.MediaId
+		}
+		return record, result, false
+	}
+
+	return record, result, true
+}
+
+// finish records the outcome of publishing and returns it as a result
+func finish(record database.PostDocument, receipt Receipt, err error) Result {
+	record.ContainerId, record.MediaId = receipt.ContainerId, receipt.Id
+	record.Status = database.PostPublished
+	record.UpdatedAt = time.Now()
+	record.PublishedAt = record.UpdatedAt
+	if err != nil {
+		record.Status = database.PostFailed
+		record.Error = err.Error()
+		record.PublishedAt = time.Time{}
+	}
+
+	if err := record.InsertOrUpdate(); err != nil {
+		fmt.Printf("error saving %s post log for %s: %v\n", record.Destination, record.Date, err)
+	}
+
+	return Result{
+		Destination: record.Destination,
+		Date:        record.Date,
+		Status:      record.Status,
+		ContainerId: record.ContainerId,
+		Id:          record.MediaId,
+		Error:       record.Error,
+	}
+}
+
+// Failed reports whether any destination failed
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Error != "" {
+			return true
+		}
+	}
+	return false
+}
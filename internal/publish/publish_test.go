@@ -0,0 +1,75 @@
+package publish
+
+import (
+	"testing"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+// blockedPublisher publishes once release is closed
+type blockedPublisher struct {
+	release chan struct{}
+}
+
+func (blockedPublisher) Name() string {
+	return "blocked"
+}
+
+func (b blockedPublisher) Publish(Post) (Receipt, error) {
+	<-b.release
+	return Receipt{Id: "1"}, nil
+}
+
+// TestHeartbeat keeps a post's pending record fresh while it publishes, and stops before the
+// outcome is recorded
+func TestHeartbeat(t *testing.T) {
+	t.Cleanup(database.UseMemory())
+	interval := heartbeatInterval
+	heartbeatInterval = time.Millisecond
+	t.Cleanup(func() { heartbeatInterval = interval })
+
+	p := blockedPublisher{release: make(chan struct{})}
+	post := dailyPost("2023-05-01")
+
+	results := make(chan Result)
+	go func() {
+		results <- publishOnce(p, post, false)
+	}()
+
+	var claimed time.Time
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record, found, err := database.GetPost(post.Date, post.Kind, p.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found && claimed.IsZero() {
+			claimed = record.UpdatedAt
+		}
+		if found && record.UpdatedAt.After(claimed) {
+			if record.Status != database.PostPending {
+				t.Fatalf("record %+v while publishing, want it pending", record)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pending record not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(p.release)
+	if r := <-results; r.Status != database.PostPublished {
+		t.Fatalf("result %+v, want it published", r)
+	}
+
+	time.Sleep(10 * heartbeatInterval)
+	record, _, err := database.GetPost(post.Date, post.Kind, p.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != database.PostPublished {
+		t.Errorf("record %+v after publishing, want it published", record)
+	}
+}
@@ -0,0 +1,54 @@
+package publish
+
+import (
+	"github.com/baely/weightloss-tracker/internal/integrations/discord"
+	"github.com/baely/weightloss-tracker/internal/integrations/slack"
+	"github.com/baely/weightloss-tracker/internal/integrations/telegram"
+)
+
+// Telegram posts the image to a channel through a bot
+type Telegram struct{}
+
+func (Telegram) Name() string {
+	return "telegram"
+}
+
+func (Telegram) configured() bool {
+	return telegram.Configured()
+}
+
+func (Telegram) Publish(post Post) (Receipt, error) {
+	id, err := telegram.SendPhoto(post.ImageUrl, post.Caption)
+	return Receipt{Id: id}, err
+}
+
+// Discord posts the image to a channel through a webhook
+type Discord struct{}
+
+func (Discord) Name() string {
+	return "discord"
+}
+
+func (Discord) configured() bool {
+	return discord.Configured()
+}
+
+func (Discord) Publish(post Post) (Receipt, error) {
+	id, err := discord.PostImage(post.ImageUrl, post.Caption)
+	return Receipt{Id: id}, err
+}
+
+// Slack posts the image to a channel through a webhook
+type Slack struct{}
+
+func (Slack) Name() string {
+	return "slack"
+}
+
+func (Slack) configured() bool {
+	return slack.Configured()
+}
+
+func (Slack) Publish(post Post) (Receipt, error) {
+	return Receipt{}, slack.PostImage(post.ImageUrl, post.Caption, post.AltText)
+}
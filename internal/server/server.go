@@ -7,16 +7,33 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 
+	"github.com/baely/weightloss-tracker/internal/analysis"
+	"github.com/baely/weightloss-tracker/internal/auth"
+	"github.com/baely/weightloss-tracker/internal/caption"
 	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/insights"
 	"github.com/baely/weightloss-tracker/internal/integrations/apple"
 	"github.com/baely/weightloss-tracker/internal/integrations/gcs"
 	"github.com/baely/weightloss-tracker/internal/integrations/meta"
 	"github.com/baely/weightloss-tracker/internal/integrations/ntfy"
+	"github.com/baely/weightloss-tracker/internal/publish"
 	"github.com/baely/weightloss-tracker/internal/util"
+	"github.com/baely/weightloss-tracker/internal/util/image"
+)
+
+const (
+	backfillDays = 14
+	backfillMax  = 5
+
+	plateauNotice = "plateau"
+	// plateauReminder is how often a plateau is notified while it lasts
+	plateauReminder = 7 * 24 * time.Hour
 )
 
 type Server struct {
@@ -39,9 +56,26 @@ func NewServer() (*Server, error) {
 	r.Post("/data", s.PostData)
 	r.Get("/privacy-policy", s.GetPrivacyPolicy)
 	r.Get("/trigger-post", s.TriggerPost)
+	r.Get("/trigger-weekly-post", s.TriggerWeeklyPost)
 	r.Get("/refresh-token", s.RefreshToken)
+	r.Post("/rotate-keys", s.RotateKeys)
 	r.Get("/new-token", s.NewLongToken)
 	r.Get("/latest-image", s.LatestImage)
+	r.Get("/analyse", s.Analyse)
+	r.Get("/streaks", s.Streaks)
+	r.Get("/timelapse", s.Timelapse)
+	r.Get("/posts", s.Posts)
+	r.Get("/collect-insights", s.CollectInsights)
+	r.Get("/insights-report", s.InsightsReport)
+
+	// Serving with a broken template would fail every render, so don't start
+	settings, err := database.GetSettings()
+	if err != nil {
+		return nil, fmt.Errorf("error getting settings: %w", err)
+	}
+	if err = image.ValidateTemplates(settings); err != nil {
+		return nil, err
+	}
 
 	s.s = http.Server{
 		Addr:    addr,
@@ -92,63 +126,364 @@ func (s *Server) GetPrivacyPolicy(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) TriggerPost(w http.ResponseWriter, r *http.Request) {
-	token, err := database.GetToken()
+	if backfill, _ := strconv.ParseBool(r.URL.Query().Get("backfill")); backfill {
+		s.backfill(w, r)
+		return
+	}
+
+	// Hack to avoid loading tz files
+	date := time.Now().Add(10*time.Hour).AddDate(0, 0, -1).Format("2006-01-02")
+
+	post, err := dailyPost(date)
 	if err != nil {
-		fmt.Println("error getting long token:", err)
+		fmt.Println("error reading image:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	pageId, busToken, err := meta.GetDetails(token.Token)
+	docs, settings, ok := captionInputs()
+	publishers := publish.Configured()
+	if ok {
+		post.Captions = captionsFor(publish.KindDaily, caption.NewData(date, 1, docs, settings), publishers)
+	}
+
+	writeResults(w, publish.PublishAll(publishers, post, forced(r)))
+}
+
+// backfill publishes daily posts from the last ?days= days (default 14) that have an image but
+// haven't been published to every destination, oldest first and at most ?max= (default 5) per
+// run. ?carousel=true combines them into carousels where the destination supports it.
+func (s *Server) backfill(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days, limit := backfillDays, backfillMax
+	if d, err := strconv.Atoi(query.Get("days")); err == nil && d > 0 {
+		days = d
+	}
+	if m, err := strconv.Atoi(query.Get("max")); err == nil && m > 0 {
+		limit = m
+	}
+	carousel, _ := strconv.ParseBool(query.Get("carousel"))
+
+	// Hack to avoid loading tz files
+	end := time.Now().Add(10*time.Hour).AddDate(0, 0, -1)
+	start := end.AddDate(0, 0, -days+1)
+
+	names, err := gcs.ListFiles(util.ResourceBucket, "weightlog/")
 	if err != nil {
-		fmt.Println("error getting details:", err)
+		fmt.Println("error listing images:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	images := make(map[string]bool, len(names))
+	for _, name := range names {
+		images[name] = true
+	}
 
-	igId, err := meta.BusinessAccount(pageId, busToken)
+	posts, err := database.GetPosts(start.Format("2006-01-02"), end.Format("2006-01-02"))
 	if err != nil {
-		fmt.Println("error getting business account:", err)
+		fmt.Println("error getting posts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	published := make(map[string]bool)
+	for _, p := range posts {
+		if p.Kind == publish.KindDaily && p.Status == database.PostPublished {
+			published[p.Date+"/"+p.Destination] = true
+		}
+	}
+
+	docs, settings, captioned := captionInputs()
+	publishers := publish.Configured()
+	var missed []publish.Post
+	for d := start; !d.After(end) && len(missed) < limit; d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		if !images[image.Filename(date, image.SizeSquare, image.FormatJPEG)] {
+			continue
+		}
+
+		pending := false
+		for _, p := range publishers {
+			pending = pending || !published[date+"/"+p.Name()]
+		}
+		if !pending {
+			continue
+		}
+
+		post, err := dailyPost(date)
+		if err != nil {
+			fmt.Printf("error reading image for %s: %v\n", date, err)
+			continue
+		}
+		if captioned {
+			post.Captions = captionsFor(publish.KindDaily, caption.NewData(date, 1, docs, settings), publishers)
+		}
+		missed = append(missed, post)
+	}
+
+	writeResults(w, publish.Backfill(publishers, missed, carousel))
+}
+
+// captionInputs reads the documents and settings captions are rendered from, returning false when
+// either can't be read. Posts then keep their plain date caption: without the settings there's
+// no telling what privacy hides, and without the documents there's nothing to show.
+func captionInputs() ([]database.Document, database.Settings, bool) {
+	docs, err := database.GetAllDocuments()
+	if err != nil {
+		fmt.Println("error getting documents:", err)
+		return nil, database.Settings{}, false
+	}
+	settings, err := database.GetSettings()
+	if err != nil {
+		fmt.Println("error getting settings:", err)
+		return nil, database.Settings{}, false
+	}
+	return docs, settings, true
+}
+
+// captionsFor renders a kind of post's captions for each publisher. Nil is returned when they
+// can't be rendered, leaving publishers with the post's plain caption.
+func captionsFor(kind string, data caption.Data, publishers []publish.Publisher) map[string]string {
+	config, err := caption.LoadConfig()
+	if err != nil {
+		fmt.Println("error loading caption config:", err)
+		return nil
+	}
+
+	names := make([]string, len(publishers))
+	for i, p := range publishers {
+		names[i] = p.Name()
+	}
+
+	captions, err := config.Render(kind, data, names)
+	if err != nil {
+		fmt.Println("error rendering captions:", err)
+		return nil
+	}
+	return captions
+}
+
+// dailyPost reads the square card for a date and builds its post
+func dailyPost(date string) (publish.Post, error) {
+	filename := image.Filename(date, image.SizeSquare, image.FormatJPEG)
+
+	f, err := gcs.ReadFile(util.ResourceBucket, filename)
+	if err != nil {
+		return publish.Post{}, err
+	}
+	img, err := io.ReadAll(f)
+	if err != nil {
+		return publish.Post{}, err
+	}
+
+	return publish.Post{
+		Date:        date,
+		Kind:        publish.KindDaily,
+		Caption:     date,
+		ImageUrl:    publicUrl(filename),
+		Image:       img,
+		ContentType: image.FormatJPEG.ContentType(),
+		AltText:     fmt.Sprintf("Daily update for %s", date),
+	}, nil
+}
+
+func (s *Server) TriggerWeeklyPost(w http.ResponseWriter, r *http.Request) {
+	days := weekDays
+	if d := r.URL.Query().Get("days"); d != "" {
+		days, _ = strconv.Atoi(d)
+		if !chartSpan(days) {
+			http.Error(w, fmt.Sprintf("days must be one of %v", chartDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	docs, err := database.GetAllDocuments()
+	if err != nil {
+		fmt.Println("error getting documents:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	// Hack to avoid loading tz files
-	date := time.Now().Add(10*time.Hour).AddDate(0, 0, -1).Format("2006-01-02")
-	file := fmt.Sprintf("https://storage.googleapis.com/res.xbd.au/weightlog/%s.jpg", date)
-	containerId, err := meta.CreateContainer(igId, file, date, busToken)
+	end := time.Now().Add(10*time.Hour).AddDate(0, 0, -1)
+	date := end.Format("2006-01-02")
+
+	// Rendering and uploading the chart, summary and carousel is only worth it for destinations
+	// that still need the post
+	publishers := publish.Configured()
+	pending := publishers
+	if !forced(r) {
+		pending = publish.Unpublished(publishers, date, publish.KindWeekly)
+	}
+	if len(pending) == 0 {
+		writeResults(w, publish.PublishAll(publishers, publish.Post{Date: date, Kind: publish.KindWeekly}, false))
+		return
+	}
+
+	// Without the settings the chart and captions would show what privacy hides
+	settings, err := database.GetSettings()
 	if err != nil {
-		fmt.Println("error creating container:", err)
-		_ = ntfy.Notify(fmt.Sprintf("Error creating Instagram container:\n%s", err))
+		fmt.Println("error getting settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = meta.PublishContent(igId, containerId, busToken)
+	img, err := image.GenerateChart(docs, date, days, image.StyleFor(settings, end))
 	if err != nil {
-		fmt.Println("error publishing content:", err)
-		_ = ntfy.Notify(fmt.Sprintf("Error publishing Instagram content:\n%s", err))
+		fmt.Println("error generating chart:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-}
 
-func (s *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	oldToken, err := database.GetToken()
+	filename := fmt.Sprintf(image.ChartFilenameFormat, date, days)
+	err = gcs.UploadFile(util.ResourceBucket, filename, bytes.NewReader(img))
 	if err != nil {
-		fmt.Println("error retrieving old token:", oldToken)
+		fmt.Println("error saving chart:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	longToken, err := meta.GetLongToken(oldToken.Token)
+	span := fmt.Sprintf("%s to %s", end.AddDate(0, 0, -days+1).Format("2006-01-02"), date)
+	post := publish.Post{
+		Date:        date,
+		Kind:        publish.KindWeekly,
+		Caption:     span,
+		ImageUrl:    publicUrl(filename),
+		Image:       img,
+		ContentType: image.FormatJPEG.ContentType(),
+		AltText:     fmt.Sprintf("Weight and energy chart for %s", span),
+	}
+	// The carousel's cards and summary cover a week, so longer spans post the chart alone
+	carousel, err := strconv.ParseBool(r.URL.Query().Get("carousel"))
+	if (err != nil || carousel) && days == weekDays && publish.Carousels(pending) {
+		post.Carousel = weeklyCarousel(post, docs, settings, end)
+	}
+
+	post.Captions = captionsFor(publish.KindWeekly, caption.NewData(date, days, docs, settings), publishers)
+
+	writeResults(w, publish.PublishAll(publishers, post, forced(r)))
+}
+
+// chartDays are the spans, in days, a chart post can cover
+var chartDays = []int{7, 30, 90}
+
+func chartSpan(days int) bool {
+	for _, d := range chartDays {
+		if d == days {
+			return true
+		}
+	}
+	return false
+}
+
+// weekDays is the span, in days, of the weekly post and its carousel
+const weekDays = 7
+
+// weeklyCarousel returns the chart post followed by the daily cards of the week up to end and a
+// summary card. Cards that can't be read or rendered are left out, as the carousel is still
+// worth posting without them.
+func weeklyCarousel(chart publish.Post, docs []database.Document, settings database.Settings, end time.Time) []publish.Post {
+	carousel := []publish.Post{chart}
+
+	for d := end.AddDate(0, 0, -weekDays+1); !d.After(end); d = d.AddDate(0, 0, 1) {
+		card, err := dailyPost(d.Format("2006-01-02"))
+		if err != nil {
+			fmt.Printf("error reading card for %s: %v\n", d.Format("2006-01-02"), err)
+			continue
+		}
+		carousel = append(carousel, card)
+	}
+
+	summary, err := summaryPost(docs, settings, end, weekDays)
 	if err != nil {
-		fmt.Println("error getting long token:", err)
+		fmt.Println("error generating summary card:", err)
+		return carousel
+	}
+	return append(carousel, summary)
+}
+
+// summaryPost renders and saves the summary card for the days up to end
+func summaryPost(docs []database.Document, settings database.Settings, end time.Time, days int) (publish.Post, error) {
+	date := end.Format("2006-01-02")
+
+	t, err := image.TemplateFor(settings, image.PostTypeSummary)
+	if err != nil {
+		return publish.Post{}, err
+	}
+
+	img, err := image.Generate(t, image.NewSummaryData(docs, date, days, settings), image.FormatJPEG)
+	if err != nil {
+		return publish.Post{}, err
+	}
+
+	filename := fmt.Sprintf(image.SummaryFilenameFormat, date, days)
+	err = gcs.UploadFile(util.ResourceBucket, filename, bytes.NewReader(img))
+	if err != nil {
+		return publish.Post{}, err
+	}
+
+	return publish.Post{
+		Date:        date,
+		Kind:        publish.KindWeekly,
+		ImageUrl:    publicUrl(filename),
+		Image:       img,
+		ContentType: image.FormatJPEG.ContentType(),
+		AltText:     fmt.Sprintf("Summary of the %d days to %s", days, date),
+	}, nil
+}
+
+// writeResults responds with the result of publishing to each destination, failing the request
+// if any destination failed so the scheduler reports it
+func writeResults(w http.ResponseWriter, results []publish.Result) {
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Printf("error posting to %s: %s\n", r.Destination, r.Error)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if publish.Failed(results) {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// forced reports whether the request asks to post again to destinations that already have the post
+func forced(r *http.Request) bool {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	return force
+}
+
+// publicUrl returns the public address of an object in the resource bucket
+func publicUrl(filename string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", util.ResourceBucket, filename)
+}
+
+// RefreshToken checks the stored token and refreshes it when it's close to expiring, or straight
+// away with ?force=true, responding with the token's status
+func (s *Server) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	status, err := auth.Check(forced(r))
+	if err != nil {
+		fmt.Println("error checking token:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	t := database.TokenDocument{Token: longToken}
-	err = t.InsertOrUpdate()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// RotateKeys re-encrypts stored credentials with the current key, after a key is added to the key
+// file or rotated in KMS
+func (s *Server) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	n, err := database.RotateCredentials()
 	if err != nil {
-		fmt.Println("error saving token to firestore:", err)
+		fmt.Println("error rotating keys:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	w.Write([]byte("Successfully updated long token"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"rotated": n})
 }
 
 func (s *Server) NewLongToken(w http.ResponseWriter, r *http.Request) {
@@ -175,8 +510,7 @@ func (s *Server) NewLongToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t := database.TokenDocument{Token: longToken}
-	err = t.InsertOrUpdate()
+	_, err = auth.Store(longToken)
 	if err != nil {
 		fmt.Println("error saving token to firestore:", err)
 		return
@@ -186,12 +520,48 @@ func (s *Server) NewLongToken(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) LatestImage(w http.ResponseWriter, r *http.Request) {
+	size := image.SizeSquare
+	if name := r.URL.Query().Get("size"); name != "" {
+		var ok bool
+		size, ok = image.SizeByName(name)
+		if !ok {
+			http.Error(w, "unknown size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	settings, err := database.GetSettings()
+	if err != nil {
+		fmt.Println("error getting settings:", err)
+	}
+
+	format := image.FormatFor(settings, image.DestinationWeb)
+	name := r.URL.Query().Get("format")
+	if name != "" {
+		var ok bool
+		format, ok = image.FormatByName(name)
+		if !ok {
+			http.Error(w, "unknown format", http.StatusBadRequest)
+			return
+		}
+	}
+
 	date := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-	fileName := fmt.Sprintf("weightlog/%s.jpg", date)
+	fileName := image.Filename(date, size, format)
 	file, err := gcs.ReadFile(util.ResourceBucket, fileName)
+	if gcs.IsNotExist(err) && name == "" && format != image.FormatJPEG {
+		// Cards rendered before the web format changed only exist as JPEG
+		format = image.FormatJPEG
+		fileName = image.Filename(date, size, format)
+		file, err = gcs.ReadFile(util.ResourceBucket, fileName)
+	}
 	if err != nil {
 		fmt.Println("error getting gcs file:", err)
 
+		if gcs.IsNotExist(err) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -203,7 +573,289 @@ func (s *Server) LatestImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", format.ContentType())
 	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "image/jpeg")
 	w.Write(b)
 }
+
+func (s *Server) Analyse(w http.ResponseWriter, r *http.Request) {
+	docs, err := database.GetAllDocuments()
+	if err != nil {
+		fmt.Println("error getting documents:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Without the settings the response could show the absolute trend privacy hides
+	settings, err := database.GetSettings()
+	if err != nil {
+		fmt.Println("error getting settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	report := analysis.Analyse(docs)
+
+	for _, doc := range report.Changed(docs) {
+		err = doc.InsertOrUpdate()
+		if err != nil {
+			fmt.Printf("error saving outliers for '%s': %v\n", doc.Title, err)
+			continue
+		}
+		if len(doc.Outliers) > 0 {
+			_ = ntfy.Notify(fmt.Sprintf("Outlier reading on %s: %s", doc.Title, strings.Join(doc.Outliers, ", ")))
+		}
+	}
+
+	if p := report.Plateau; p != nil {
+		notifyPlateau(p)
+	}
+
+	// Trend weights are absolute, so keep them out of the response when public images are relative
+	if image.PrivacyFor(settings).Relative() {
+		report.Trend = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// notifyPlateau sends a notification when a plateau is found, then reminds once a week for as long
+// as it lasts. The last notice is recorded, so a missed run delays the reminder rather than
+// skipping a week.
+func notifyPlateau(p *analysis.Plateau) {
+	notice, err := database.GetNotice(plateauNotice)
+	if err != nil {
+		fmt.Println("error getting plateau notice:", err)
+		return
+	}
+	// The start of a plateau can move as days are added, so it can't key the notice. Instead a
+	// notice sent since the plateau began is about the same plateau. Hack to avoid loading tz files
+	ongoing := !notice.SentAt.IsZero() && notice.SentAt.Add(10*time.Hour).Format("2006-01-02") >= p.Start
+	if ongoing && time.Since(notice.SentAt) < plateauReminder {
+		return
+	}
+
+	err = ntfy.Notify(fmt.Sprintf("Weight has plateaued for %d days since %s (%+.1f kg)", p.Days, p.Start, p.Change))
+	if err != nil {
+		fmt.Println("error sending plateau notice:", err)
+		return
+	}
+
+	notice.SentAt = time.Now()
+	err = notice.InsertOrUpdate()
+	if err != nil {
+		fmt.Println("error saving plateau notice:", err)
+	}
+}
+
+func (s *Server) Streaks(w http.ResponseWriter, r *http.Request) {
+	docs, err := database.GetAllDocuments()
+	if err != nil {
+		fmt.Println("error getting documents:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := database.GetSettings()
+	if err != nil {
+		fmt.Println("error getting settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Hack to avoid loading tz files
+	date := time.Now().Add(10 * time.Hour).Format("2006-01-02")
+	streaks := analysis.ComputeStreaks(docs, date, analysis.RuleFromSettings(settings))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(streaks)
+}
+
+func (s *Server) Posts(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 30)
+	if !ok {
+		return
+	}
+
+	posts, err := database.GetPosts(from, to)
+	if err != nil {
+		fmt.Println("error getting posts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if destination := r.URL.Query().Get("destination"); destination != "" {
+		filtered := make([]database.PostDocument, 0, len(posts))
+		for _, p := range posts {
+			if p.Destination == destination {
+				filtered = append(filtered, p)
+			}
+		}
+		posts = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// dateRange reads the from and to query parameters, defaulting to the last days days. It writes a
+// bad request and returns false when either isn't a date.
+func dateRange(w http.ResponseWriter, r *http.Request, days int) (string, string, bool) {
+	query := r.URL.Query()
+
+	// Hack to avoid loading tz files
+	now := time.Now().Add(10 * time.Hour)
+	from := now.AddDate(0, 0, -days).Format("2006-01-02")
+	to := now.Format("2006-01-02")
+	if f := query.Get("from"); f != "" {
+		from = f
+	}
+	if t := query.Get("to"); t != "" {
+		to = t
+	}
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			http.Error(w, "from and to must be in YYYY-MM-DD form", http.StatusBadRequest)
+			return "", "", false
+		}
+	}
+
+	return from, to, true
+}
+
+func (s *Server) CollectInsights(w http.ResponseWriter, r *http.Request) {
+	results, err := insights.Collect(time.Now())
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Printf("error collecting %s insights for %s: %s\n", result.Kind, result.Date, result.Error)
+		}
+	}
+
+	// Headers have to be set before the status is written
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		fmt.Println("error collecting insights:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+func (s *Server) InsightsReport(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := dateRange(w, r, 90)
+	if !ok {
+		return
+	}
+
+	posts, err := database.GetPosts(from, to)
+	if err != nil {
+		fmt.Println("error getting posts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	docs, err := database.GetAllDocuments()
+	if err != nil {
+		fmt.Println("error getting documents:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := database.GetSettings()
+	if err != nil {
+		fmt.Println("error getting settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(insights.BuildReport(posts, docs, settings))
+}
+
+func (s *Server) Timelapse(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Defaults to last month. Hack to avoid loading tz files
+	now := time.Now().Add(10 * time.Hour)
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	if m := query.Get("month"); m != "" {
+		var err error
+		month, err = time.Parse("2006-01", m)
+		if err != nil {
+			http.Error(w, "month must be in YYYY-MM form", http.StatusBadRequest)
+			return
+		}
+	}
+	start := month.Format("2006-01-02")
+	end := month.AddDate(0, 1, -1).Format("2006-01-02")
+
+	kind := image.TimelapseCards
+	if k := query.Get("kind"); k != "" {
+		kind = image.TimelapseKind(k)
+	}
+
+	docs, err := database.GetAllDocuments()
+	if err != nil {
+		fmt.Println("error getting documents:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Without the settings the timelapse would show what privacy hides
+	settings, err := database.GetSettings()
+	if err != nil {
+		fmt.Println("error getting settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var img []byte
+	switch kind {
+	case image.TimelapseCards:
+		img, err = s.cardTimelapse(docs, settings, start, end)
+	case image.TimelapseChart:
+		img, err = s.chartTimelapse(docs, settings, start, end)
+	default:
+		http.Error(w, "unknown kind", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		fmt.Println("error generating timelapse:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf(image.TimelapseFilenameFormat, month.Format("2006-01"), kind)
+	err = gcs.UploadFile(util.ResourceBucket, filename, bytes.NewReader(img))
+	if err != nil {
+		fmt.Println("error saving timelapse:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Write(img)
+}
+
+func (s *Server) chartTimelapse(docs []database.Document, settings database.Settings, start, end string) ([]byte, error) {
+	// Hack to avoid loading tz files
+	now := time.Now().Add(10 * time.Hour)
+	return image.GenerateChartTimelapse(docs, start, end, image.StyleFor(settings, now))
+}
+
+func (s *Server) cardTimelapse(docs []database.Document, settings database.Settings, start, end string) ([]byte, error) {
+	tmpl, err := image.TemplateFor(settings, image.PostTypeDaily)
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]image.Data, 0)
+	for _, doc := range docs {
+		if doc.Title < start || doc.Title > end {
+			continue
+		}
+		days = append(days, image.NewData(doc, docs, settings))
+	}
+
+	return image.GenerateCardTimelapse(tmpl, days)
+}
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPClient is the client for requests to other services. Its timeout stops a destination that
+// stops responding from holding up a post and the destinations after it.
+var HTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+// RedactURL replaces the address in the error of a request that failed without a response with
+// uri. Errors end up in the post log and in responses, so an address holding a token or webhook
+// secret mustn't be in them.
+func RedactURL(err error, uri string) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = uri
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+
+	"github.com/golang/freetype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/baely/weightloss-tracker/internal/analysis"
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// ChartFilenameFormat is the object name for a chart, formatted with the end date and number of days
+	ChartFilenameFormat = "weightlog/chart/%s-%dd.jpg"
+
+	// legendGap is the space between legend entries
+	legendGap = 40
+)
+
+// textSpec is a string to draw and how to draw it
+type textSpec struct {
+	font  string
+	size  float64
+	src   image.Image
+	text  string
+	point fixed.Point26_6
+}
+
+// plot maps a run of days and a value range onto a rectangle of the image
+type plot struct {
+	rect     image.Rectangle
+	days     int
+	min, max float64
+}
+
+// x returns the horizontal centre of the slot for the given day
+func (p plot) x(day int) int {
+	slot := float64(p.rect.Dx()) / float64(p.days)
+	return p.rect.Min.X + int(slot*(float64(day)+0.5))
+}
+
+// y returns the vertical position for a value
+func (p plot) y(v float64) int {
+	if p.max == p.min {
+		return p.rect.Min.Y + p.rect.Dy()/2
+	}
+	frac := (v - p.min) / (p.max - p.min)
+	return p.rect.Max.Y - int(frac*float64(p.rect.Dy()))
+}
+
+// GenerateChart draws raw and trend weight, and intake against expenditure, for the given number
+// of days up to and including end. Under a relative privacy mode the weight axis is labelled
+// relative to the start of the window, and hidden fields aren't plotted.
+func GenerateChart(docs []database.Document, end string, days int, style Style) ([]byte, error) {
+	img, err := drawChart(docs, end, days, days, style)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(img, FormatJPEG)
+}
+
+// drawChart draws the chart with only the first drawn days plotted. The axes always cover the
+// full window so partial charts line up as animation frames.
+func drawChart(docs []database.Document, end string, days int, drawn int, style Style) (*image.RGBA, error) {
+	privacy, palette, locale := style.Privacy, style.Palette, style.Locale
+
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return nil, err
+	}
+	startDate := endDate.AddDate(0, 0, -days+1)
+	start := startDate.Format("2006-01-02")
+
+	// The trend is computed over the whole series so the start of the window is already settled
+	report := analysis.Analyse(docs)
+	trend := make(map[string]analysis.TrendPoint)
+	for _, p := range report.Trend {
+		trend[p.Date] = p
+	}
+	byDate := make(map[string]database.Document)
+	for _, doc := range docs {
+		if doc.Title >= start && doc.Title <= end {
+			byDate[doc.Title] = doc
+		}
+	}
+
+	width, height := 1080, 1080
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(palette.role("background")), image.Point{}, draw.Src)
+
+	c := newContext()
+	err = c.loadFonts("Roboto-Regular.ttf", "CarterOne-Regular.ttf")
+	if err != nil {
+		return nil, err
+	}
+
+	c.SetClip(img.Bounds())
+	c.SetDst(img)
+
+	// Colors
+	ink := palette.role("text")
+	midGrey := palette.role("muted")
+	red := palette.role("accent")
+	pink := palette.role("highlight")
+
+	draw.Draw(img, image.Rect(0, 0, 1080, 200), image.NewUniform(palette.role("panel")), image.Point{}, draw.Src)
+
+	title := fmt.Sprintf(locale.label("day_recap"), days)
+	if days == 7 {
+		title = locale.label("weekly_recap")
+	}
+
+	// Weight plot
+	weightPlot := plot{rect: image.Rect(80, 300, 1000, 620), days: days}
+	weightPlot.min, weightPlot.max = math.Inf(1), math.Inf(-1)
+	for d := 0; d < days; d++ {
+		p, ok := trend[startDate.AddDate(0, 0, d).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		for _, v := range []float64{p.Weight, p.Trend} {
+			if v == 0 {
+				continue
+			}
+			weightPlot.min = math.Min(weightPlot.min, v)
+			weightPlot.max = math.Max(weightPlot.max, v)
+		}
+	}
+	if math.IsInf(weightPlot.min, 0) {
+		weightPlot.min, weightPlot.max = 0, 0
+	}
+	weightPlot.min -= 0.5
+	weightPlot.max += 0.5
+
+	// Axis labels are offsets from the first trend weight in the window when weights are private
+	var baseline float64
+	for d := 0; d < days && privacy.Relative(); d++ {
+		if p, ok := trend[startDate.AddDate(0, 0, d).Format("2006-01-02")]; ok {
+			baseline = p.Trend
+			break
+		}
+	}
+	weightLabel := func(v float64) string {
+		if privacy.Relative() {
+			return locale.number(v-baseline, 1, true)
+		}
+		return locale.number(v, 1, false)
+	}
+
+	var prev *image.Point
+	for d := 0; d < drawn && !privacy.hides("Weight"); d++ {
+		p, ok := trend[startDate.AddDate(0, 0, d).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		if p.Weight != 0 {
+			fillCircle(img, image.Pt(weightPlot.x(d), weightPlot.y(p.Weight)), 7, midGrey)
+		}
+		pt := image.Pt(weightPlot.x(d), weightPlot.y(p.Trend))
+		if prev != nil {
+			drawLine(img, *prev, pt, 6, red)
+		}
+		prev = &pt
+	}
+
+	// Energy plot
+	energyPlot := plot{rect: image.Rect(80, 720, 1000, 1000), days: days}
+	for _, doc := range byDate {
+		energyPlot.max = math.Max(energyPlot.max, math.Max(doc.IntakeEnergy, doc.ActiveEnergy+doc.RestingEnergy))
+	}
+	energyPlot.max *= 1.1
+
+	barWidth := int(math.Max(2, 0.7*float64(energyPlot.rect.Dx())/float64(days)))
+	prev = nil
+	for d := 0; d < drawn; d++ {
+		doc, ok := byDate[startDate.AddDate(0, 0, d).Format("2006-01-02")]
+		if !ok {
+			prev = nil
+			continue
+		}
+		x := energyPlot.x(d)
+		if doc.IntakeEnergy != 0 && !privacy.hides("IntakeEnergy") {
+			bar := image.Rect(x-barWidth/2, energyPlot.y(doc.IntakeEnergy), x+barWidth/2, energyPlot.rect.Max.Y)
+			draw.Draw(img, bar, image.NewUniform(pink), image.Point{}, draw.Src)
+		}
+		expenditure := doc.ActiveEnergy + doc.RestingEnergy
+		if expenditure == 0 || privacy.hides("ActiveEnergy") || privacy.hides("RestingEnergy") {
+			prev = nil
+			continue
+		}
+		pt := image.Pt(x, energyPlot.y(expenditure))
+		if prev != nil {
+			drawLine(img, *prev, pt, 4, ink)
+		} else {
+			fillCircle(img, pt, 3, ink)
+		}
+		prev = &pt
+	}
+
+	// Axes
+	for _, r := range []image.Rectangle{weightPlot.rect, energyPlot.rect} {
+		draw.Draw(img, image.Rect(r.Min.X, r.Max.Y, r.Max.X, r.Max.Y+3), image.NewUniform(ink), image.Point{}, draw.Src)
+	}
+
+	// Translated titles can run long, so they're shrunk to fit the header
+	subtitle := fmt.Sprintf(locale.label("date_range"), locale.date(startDate), locale.date(endDate))
+	titleSize, _, err := c.fit(Text{Font: "CarterOne-Regular.ttf", Size: 96, MaxWidth: width - 40}, locale.visual(title))
+	if err != nil {
+		return nil, err
+	}
+	subtitleSize, _, err := c.fit(Text{Font: "Roboto-Regular.ttf", Size: 48, MaxWidth: width - 40}, locale.visual(subtitle))
+	if err != nil {
+		return nil, err
+	}
+
+	texts := []textSpec{
+		{"CarterOne-Regular.ttf", titleSize, image.NewUniform(ink), title, freetype.Pt(20, 110)},
+		{"Roboto-Regular.ttf", subtitleSize, image.NewUniform(ink), subtitle, freetype.Pt(20, 175)},
+		{"Roboto-Regular.ttf", 48, image.NewUniform(ink), locale.label("weight"), freetype.Pt(80, 270)},
+		{"Roboto-Regular.ttf", 28, image.NewUniform(ink), weightLabel(weightPlot.max), freetype.Pt(10, weightPlot.rect.Min.Y+10)},
+		{"Roboto-Regular.ttf", 28, image.NewUniform(ink), weightLabel(weightPlot.min), freetype.Pt(10, weightPlot.rect.Max.Y)},
+		{"Roboto-Regular.ttf", 48, image.NewUniform(ink), locale.label("energy"), freetype.Pt(80, 690)},
+		{"Roboto-Regular.ttf", 28, image.NewUniform(ink), locale.number(energyPlot.max, 0, false), freetype.Pt(10, energyPlot.rect.Min.Y+10)},
+		{"Roboto-Regular.ttf", 28, image.NewUniform(ink), "0", freetype.Pt(10, energyPlot.rect.Max.Y)},
+	}
+
+	// Legends are only drawn for the series that are plotted, and are right aligned to the plot
+	var weightLegend, energyLegend []textSpec
+	if !privacy.hides("Weight") {
+		weightLegend = append(weightLegend,
+			textSpec{"Roboto-Regular.ttf", 32, image.NewUniform(red), locale.label("trend"), freetype.Pt(0, 270)},
+			textSpec{"Roboto-Regular.ttf", 32, image.NewUniform(midGrey), locale.label("raw"), freetype.Pt(0, 270)},
+		)
+	}
+	if !privacy.hides("IntakeEnergy") {
+		energyLegend = append(energyLegend, textSpec{"Roboto-Regular.ttf", 32, image.NewUniform(pink), locale.label("intake"), freetype.Pt(0, 690)})
+	}
+	if !privacy.hides("ActiveEnergy") && !privacy.hides("RestingEnergy") {
+		energyLegend = append(energyLegend, textSpec{"Roboto-Regular.ttf", 32, image.NewUniform(ink), locale.label("expenditure"), freetype.Pt(0, 690)})
+	}
+	for _, legend := range [][]textSpec{weightLegend, energyLegend} {
+		right := fixed.I(weightPlot.rect.Max.X)
+		for i := len(legend) - 1; i >= 0; i-- {
+			w, err := c.measure(legend[i].font, legend[i].size, locale.visual(legend[i].text))
+			if err != nil {
+				return nil, err
+			}
+			legend[i].point.X = right - w
+			right -= w + fixed.I(legendGap)
+		}
+		texts = append(texts, legend...)
+	}
+
+	for _, text := range texts {
+		err = c.writeString(text.font, text.size, text.src, locale.visual(text.text), text.point)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return img, nil
+}
+
+// fillCircle draws a filled circle of radius r centred on p
+func fillCircle(img draw.Image, p image.Point, r int, c color.Color) {
+	src := image.NewUniform(c)
+	for dy := -r; dy <= r; dy++ {
+		dx := int(math.Sqrt(float64(r*r - dy*dy)))
+		draw.Draw(img, image.Rect(p.X-dx, p.Y+dy, p.X+dx+1, p.Y+dy+1), src, image.Point{}, draw.Src)
+	}
+}
+
+// drawLine draws a line of the given width between two points with rounded ends
+func drawLine(img draw.Image, a, b image.Point, width int, c color.Color) {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	steps := int(math.Max(math.Abs(dx), math.Abs(dy)))
+	if steps == 0 {
+		steps = 1
+	}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		p := image.Pt(a.X+int(math.Round(t*dx)), a.Y+int(math.Round(t*dy)))
+		fillCircle(img, p, width/2, c)
+	}
+}
@@ -0,0 +1,213 @@
+package image
+
+import (
+	"image/color"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/analysis"
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+// PrivacyMode chooses how body weight is shown on public cards
+type PrivacyMode string
+
+const (
+	// PrivacyAbsolute shows the weight as logged
+	PrivacyAbsolute PrivacyMode = "absolute"
+	// PrivacyChange shows the change in kg since the first weigh-in
+	PrivacyChange PrivacyMode = "change"
+	// PrivacyPercent shows the change as a percentage of the first weigh-in
+	PrivacyPercent PrivacyMode = "percent"
+	// PrivacyTrend shows the change in trend weight over the last week
+	PrivacyTrend PrivacyMode = "trend"
+)
+
+// Privacy controls how much of the raw data a card reveals
+type Privacy struct {
+	Mode PrivacyMode
+	// Hidden lists fields left off the card entirely, along with their labels, units and panels
+	Hidden []string
+}
+
+// relativeWeights are the field, format and unit that replace Weight in each relative mode
+var relativeWeights = map[PrivacyMode]struct {
+	field  string
+	format string
+	unit   string
+}{
+	PrivacyChange:  {"WeightChange", "%+.1f", "kg"},
+	PrivacyPercent: {"WeightPercent", "%+.1f", "%"},
+	PrivacyTrend:   {"TrendDelta", "%+.1f", "kg"},
+}
+
+// PrivacyFor returns the privacy the user has chosen for public images
+func PrivacyFor(settings database.Settings) Privacy {
+	mode := PrivacyMode(settings.Privacy)
+	if _, ok := relativeWeights[mode]; !ok {
+		mode = PrivacyAbsolute
+	}
+	return Privacy{Mode: mode, Hidden: settings.HiddenFields}
+}
+
+// Relative reports whether absolute weights are kept off the card
+func (p Privacy) Relative() bool {
+	return p.Mode != PrivacyAbsolute && p.Mode != ""
+}
+
+// RelativeField returns the field shown in place of Weight in a relative mode, empty otherwise
+func (p Privacy) RelativeField() string {
+	return relativeWeights[p.Mode].field
+}
+
+func (p Privacy) hides(field string) bool {
+	for _, h := range p.Hidden {
+		if h == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Style is how the user wants cards and charts presented
+type Style struct {
+	Privacy Privacy
+	Palette Palette
+	Locale  Locale
+}
+
+// StyleFor returns the user's style at the given local time
+func StyleFor(settings database.Settings, now time.Time) Style {
+	return Style{
+		Privacy: PrivacyFor(settings),
+		Palette: PaletteFor(settings, now),
+		Locale:  LocaleFor(settings),
+	}
+}
+
+// Data is the information shown on a daily card
+type Data struct {
+	database.Document
+	// Streak is the current logging streak in days, 0 leaves it off the card
+	Streak   int
+	Progress analysis.Progress
+	// IntakeTarget is the daily intake target in kJ, 0 when there isn't one
+	IntakeTarget float64
+	// Week summarises the days up to the document on summary cards, zero on daily cards
+	Week Week
+	Style
+}
+
+// cardPostedAfter is how long after the start of its date a daily card is posted, as the
+// scheduler posts yesterday's card at 08:30
+const cardPostedAfter = 32*time.Hour + 30*time.Minute
+
+// NewData builds the card for a document, with streak and progress computed from the full
+// document series
+func NewData(doc database.Document, docs []database.Document, settings database.Settings) Data {
+	data := Data{
+		Document:     doc,
+		Progress:     analysis.ComputeProgress(docs, doc.Title),
+		IntakeTarget: settings.IntakeTarget,
+		Style:        StyleFor(settings, cardTime(doc.Title)),
+	}
+
+	if settings.ShowStreak {
+		streaks := analysis.ComputeStreaks(docs, doc.Title, analysis.RuleFromSettings(settings))
+		data.Streak = streaks.Complete.Current
+	}
+
+	return data
+}
+
+// cardTime is the local time a card for the date is posted, which the auto palette follows, so
+// a card looks the same however often its date is rendered. Titles that aren't dates use the
+// current time.
+func cardTime(title string) time.Time {
+	date, err := time.Parse("2006-01-02", title)
+	if err != nil {
+		// Hack to avoid loading tz files
+		return time.Now().Add(10 * time.Hour)
+	}
+	return date.Add(cardPostedAfter)
+}
+
+// fields returns the values a template text can bind to, by name
+func (d Data) fields() map[string]interface{} {
+	// Date is left zero, and so blank, when the title isn't a date
+	date, _ := time.Parse("2006-01-02", d.Title)
+	weekStart, _ := time.Parse("2006-01-02", d.Week.Start)
+
+	return map[string]interface{}{
+		"Title":         d.Title,
+		"Date":          date,
+		"Weight":        d.Weight,
+		"IntakeEnergy":  d.IntakeEnergy,
+		"ActiveEnergy":  d.ActiveEnergy,
+		"RestingEnergy": d.RestingEnergy,
+		"Streak":        float64(d.Streak),
+		"WeightChange":  d.Progress.Change,
+		"WeightPercent": d.Progress.Percent,
+		"TrendDelta":    d.Progress.TrendDelta,
+		"IntakeTarget":  d.IntakeTarget,
+
+		"WeekStart":      weekStart,
+		"DaysLogged":     float64(d.Week.Logged),
+		"AverageIntake":  d.Week.AverageIntake,
+		"AverageActive":  d.Week.AverageActive,
+		"AverageResting": d.Week.AverageResting,
+	}
+}
+
+// resolve returns the string to draw for a text, applying the privacy settings, and whether it
+// is drawn at all
+func (d Data) resolve(t Text) (string, bool) {
+	for _, field := range []string{t.Field, t.Of} {
+		if field != "" && d.Privacy.hides(field) {
+			return "", false
+		}
+	}
+
+	relative, ok := relativeWeights[d.Privacy.Mode]
+	if ok && t.Unit && t.Of == "Weight" {
+		return relative.unit, true
+	}
+	if ok && t.Field == "Weight" {
+		t.Field, t.Format = relative.field, relative.format
+	}
+
+	if t.Label != "" && t.Field == "" {
+		return d.Locale.label(t.Label), true
+	}
+	if t.Label != "" {
+		t.Format = d.Locale.label(t.Label)
+	}
+
+	fields := d.fields()
+	if t.hidden(fields) {
+		return "", false
+	}
+	return t.value(fields, d.Locale), true
+}
+
+// panelShown reports whether a panel is drawn under the privacy settings
+func (d Data) panelShown(p Panel) bool {
+	return p.Of == "" || !d.Privacy.hides(p.Of)
+}
+
+// textColour returns the colour of a text, applying its threshold
+func (d Data) textColour(t Text) (color.NRGBA, error) {
+	colour := t.Colour
+	if th := t.Threshold; th != nil {
+		fields := d.fields()
+		value, _ := fields[t.Field].(float64)
+		limit, _ := fields[th.Field].(float64)
+		switch {
+		case limit == 0:
+		case value <= limit && th.Below != "":
+			colour = th.Below
+		case value > limit && th.Above != "":
+			colour = th.Above
+		}
+	}
+	return d.Palette.colour(colour)
+}
@@ -0,0 +1,50 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+// TestAutoPalette picks the auto palette from the card's date, not when it's rendered
+func TestAutoPalette(t *testing.T) {
+	settings := database.Settings{Palette: PaletteAuto}
+	doc := database.Document{Title: "2023-05-28", Weight: 100}
+
+	data := NewData(doc, []database.Document{doc}, settings)
+	want := PaletteFor(settings, cardTime(doc.Title))
+	if data.Palette["background"] != want["background"] {
+		t.Errorf("background %s, want %s", data.Palette["background"], want["background"])
+	}
+	// Posted at 08:30 the next morning
+	if data.Palette["background"] != palettes[PaletteLight]["background"] {
+		t.Errorf("background %s, want the light palette's %s", data.Palette["background"], palettes[PaletteLight]["background"])
+	}
+}
+
+// TestThreshold colours intake on the target as good, as adherence counts it
+func TestThreshold(t *testing.T) {
+	text := Text{Field: "IntakeEnergy", Colour: "text", Threshold: &Threshold{Field: "IntakeTarget", Below: "good", Above: "bad"}}
+	palette := palettes[PaletteLight]
+
+	tests := []struct {
+		intake float64
+		want   string
+	}{
+		{8000, "good"},
+		{8500, "good"},
+		{9000, "bad"},
+	}
+
+	for _, tt := range tests {
+		data := Data{Document: database.Document{IntakeEnergy: tt.intake}, IntakeTarget: 8500, Style: Style{Palette: palette}}
+		got, err := data.textColour(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := palette.colour(tt.want)
+		if got != want {
+			t.Errorf("intake %g coloured %v, want %s %v", tt.intake, got, tt.want, want)
+		}
+	}
+}
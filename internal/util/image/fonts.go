@@ -0,0 +1,189 @@
+package image
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/freetype/truetype"
+
+	"github.com/baely/weightloss-tracker/internal/integrations/gcs"
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const (
+	// DefaultFont is the bundled font used as the fallback for glyphs missing from a text's font
+	DefaultFont = "Go-Regular.ttf"
+	// ScriptFont is the bundled font tried after DefaultFont, for scripts such as Hebrew that the
+	// other bundled fonts don't cover
+	ScriptFont = "DejaVuSans.ttf"
+)
+
+// Carter One, the templates' display font, is fetched with its license from Google Fonts by go
+// generate. Once it is in fonts it is embedded and its substitute is never used.
+//go:generate curl -fsSLo fonts/CarterOne-Regular.ttf https://github.com/google/fonts/raw/main/ofl/carterone/CarterOne-Regular.ttf
+//go:generate curl -fsSLo fonts/CarterOne-OFL.txt https://github.com/google/fonts/raw/main/ofl/carterone/OFL.txt
+
+//go:embed fonts/*.ttf
+var bundledFonts embed.FS
+
+// fontSubstitutes maps fonts the templates use that aren't bundled to the bundled font drawn in
+// their place when the static bucket doesn't have them either
+var fontSubstitutes = map[string]string{
+	"CarterOne-Regular.ttf": "Go-Bold.ttf",
+}
+
+// fallbackFonts are tried in order for glyphs missing from a text's font. Extra fonts, such as an
+// emoji font, can be listed in IMAGE_FALLBACK_FONTS and are loaded from the static bucket.
+var fallbackFonts = fallbackFontNames()
+
+// fontOverrides are bundled fonts, listed in IMAGE_FONT_OVERRIDES, read from the static bucket
+// instead so a font can be updated without a redeploy
+var fontOverrides = envList("IMAGE_FONT_OVERRIDES")
+
+var fontCache = struct {
+	sync.Mutex
+	fonts  map[string]*truetype.Font
+	remote bool
+}{fonts: make(map[string]*truetype.Font), remote: true}
+
+func fallbackFontNames() []string {
+	return append(envList("IMAGE_FALLBACK_FONTS"), DefaultFont, ScriptFont)
+}
+
+func envList(key string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(os.Getenv(key), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// DisableRemoteFonts stops fonts being loaded from the static bucket, so rendering only uses the
+// bundled fonts and never touches the network
+func DisableRemoteFonts() {
+	fontCache.Lock()
+	defer fontCache.Unlock()
+
+	fontCache.remote = false
+	fontCache.fonts = make(map[string]*truetype.Font)
+}
+
+// loadFont returns a parsed font by name. Fonts are parsed once per process. Bundled fonts are
+// read from the binary unless overridden; anything else is read from the static bucket, falling
+// back to a bundled substitute if the bucket can't provide it.
+func loadFont(name string) (*truetype.Font, error) {
+	fontCache.Lock()
+	f, ok := fontCache.fonts[name]
+	remote := fontCache.remote
+	fontCache.Unlock()
+	if ok {
+		return f, nil
+	}
+
+	b, err := bundledFonts.ReadFile("fonts/" + name)
+	bundled := err == nil
+
+	// The bucket is read without the lock held, so a slow read doesn't hold up renders using
+	// fonts already loaded. A font missing from the bucket is settled for good, but a failed
+	// read is retried next time.
+	cache := true
+	if remote && (!bundled || overridden(name)) {
+		remoteFont, remoteErr := readRemoteFont(name)
+		switch {
+		case remoteErr == nil:
+			b, err = remoteFont, nil
+		case bundled:
+			fmt.Printf("error loading font override '%s' from bucket, using the bundled font: %v\n", name, remoteErr)
+			cache = gcs.IsNotExist(remoteErr)
+		default:
+			fmt.Printf("error loading font '%s' from bucket: %v\n", name, remoteErr)
+			cache = gcs.IsNotExist(remoteErr)
+		}
+	}
+	if err != nil {
+		substitute, ok := fontSubstitutes[name]
+		if !ok {
+			return nil, fmt.Errorf("font %q not available", name)
+		}
+		fmt.Printf("font '%s' isn't bundled or in the bucket, drawing it with '%s'\n", name, substitute)
+		b, err = bundledFonts.ReadFile("fonts/" + substitute)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err = truetype.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache {
+		fontCache.Lock()
+		fontCache.fonts[name] = f
+		fontCache.Unlock()
+	}
+	return f, nil
+}
+
+func overridden(name string) bool {
+	for _, o := range fontOverrides {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+func readRemoteFont(name string) ([]byte, error) {
+	fontFile, err := gcs.ReadFile(util.StaticResourceBucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(fontFile)
+}
+
+// fontRun is a stretch of text drawn with a single font
+type fontRun struct {
+	font *truetype.Font
+	text string
+}
+
+// splitRuns breaks text into runs, using the primary font where it has the glyph and the first
+// fallback that does otherwise. Spaces stay with the current run.
+func splitRuns(primary *truetype.Font, fallbacks []*truetype.Font, text string) []fontRun {
+	runs := make([]fontRun, 0, 1)
+
+	var current *truetype.Font
+	var sb strings.Builder
+	for _, r := range text {
+		f := primary
+		if r != ' ' && primary.Index(r) == 0 {
+			for _, fallback := range fallbacks {
+				if fallback.Index(r) != 0 {
+					f = fallback
+					break
+				}
+			}
+		} else if r == ' ' && current != nil {
+			f = current
+		}
+
+		if current != nil && f != current {
+			runs = append(runs, fontRun{font: current, text: sb.String()})
+			sb.Reset()
+		}
+		current = f
+		sb.WriteRune(r)
+	}
+	if current != nil {
+		runs = append(runs, fontRun{font: current, text: sb.String()})
+	}
+
+	return runs
+}
@@ -0,0 +1,123 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+// Format is an output encoding for rendered cards
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatWebP Format = "webp"
+	FormatSVG  Format = "svg"
+)
+
+// Formats lists every supported output format
+var Formats = []Format{FormatJPEG, FormatPNG, FormatWebP, FormatSVG}
+
+const (
+	// DestinationInstagram is where the daily post is published. The Graph API only accepts JPEG.
+	DestinationInstagram = "instagram"
+	// DestinationWeb is the public latest image endpoint
+	DestinationWeb = "web"
+)
+
+// destinationFormats are the formats used for each destination unless the settings override them
+var destinationFormats = map[string]Format{
+	DestinationInstagram: FormatJPEG,
+	DestinationWeb:       FormatPNG,
+}
+
+// FormatByName returns the format with the given name
+func FormatByName(name string) (Format, bool) {
+	for _, f := range Formats {
+		if string(f) == name {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// Extension returns the file extension for the format
+func (f Format) Extension() string {
+	if f == FormatJPEG {
+		return "jpg"
+	}
+	return string(f)
+}
+
+// ContentType returns the MIME type for the format
+func (f Format) ContentType() string {
+	if f == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/" + string(f)
+}
+
+// FormatByContentType returns the format with the given MIME type
+func FormatByContentType(contentType string) (Format, bool) {
+	for _, f := range Formats {
+		if f.ContentType() == contentType {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// FormatFor returns the format to render for a destination
+func FormatFor(settings database.Settings, destination string) Format {
+	if f, ok := FormatByName(settings.Formats[destination]); ok {
+		// Instagram can't take anything but JPEG whatever the settings say
+		if destination != DestinationInstagram {
+			return f
+		}
+	}
+	if f, ok := destinationFormats[destination]; ok {
+		return f
+	}
+	return FormatJPEG
+}
+
+// OutputFormats returns the distinct formats needed across every destination
+func OutputFormats(settings database.Settings) []Format {
+	seen := make(map[Format]bool)
+	formats := make([]Format, 0)
+	for _, destination := range []string{DestinationInstagram, DestinationWeb} {
+		f := FormatFor(settings, destination)
+		if !seen[f] {
+			seen[f] = true
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// encode encodes a raster image in the given format
+func encode(img image.Image, format Format) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch format {
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	case FormatPNG:
+		err = png.Encode(&buf, img)
+	case FormatWebP:
+		err = EncodeWebP(&buf, img)
+	default:
+		return nil, fmt.Errorf("format %q can't encode a raster image", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
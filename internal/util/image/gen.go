@@ -1,31 +1,30 @@
 package image
 
 import (
-	"bytes"
 	"fmt"
 	"image"
-	"image/color"
 	"image/draw"
-	"image/jpeg"
-	"io"
+	"math"
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
+	xfont "golang.org/x/image/font"
 	"golang.org/x/image/math/fixed"
-
-	"github.com/baely/weightloss-tracker/internal/database"
-	"github.com/baely/weightloss-tracker/internal/integrations/gcs"
-	"github.com/baely/weightloss-tracker/internal/util"
 )
 
 const (
-	FilenameFormat = "weightlog/%s.jpg"
+	// FilenameFormat is the object name for a square card, formatted with the date and extension
+	FilenameFormat = "weightlog/%s.%s"
+
+	// minFitScale is the smallest fraction of its size that text is shrunk to when fitting
+	minFitScale = 0.25
 )
 
 // Context wraps the freetype context and provides utility methods for font operations
 type context struct {
 	*freetype.Context
-	fonts map[string]*truetype.Font
+	fonts     map[string]*truetype.Font
+	fallbacks []*truetype.Font
 }
 
 // NewContext initializes a new freetype context
@@ -35,57 +34,183 @@ func newContext() *context {
 	}
 }
 
-// LoadFonts loads the specified fonts into the context
+// LoadFonts loads the specified fonts and the fallback fonts into the context
 func (c *context) loadFonts(fonts ...string) error {
 	fontMap := make(map[string]*truetype.Font)
 
 	for _, fontName := range fonts {
-		fontFile, err := gcs.ReadFile(util.StaticResourceBucket, fontName)
-		if err != nil {
-			return err
-		}
-		b, err := io.ReadAll(fontFile)
+		f, err := loadFont(fontName)
 		if err != nil {
 			return err
 		}
-		f, err := truetype.Parse(b)
+		fontMap[fontName] = f
+	}
+
+	fallbacks := make([]*truetype.Font, 0, len(fallbackFonts))
+	for _, fontName := range fallbackFonts {
+		f, err := loadFont(fontName)
 		if err != nil {
-			return err
+			fmt.Printf("error loading fallback font '%s': %v\n", fontName, err)
+			continue
 		}
-		fontMap[fontName] = f
+		fallbacks = append(fallbacks, f)
 	}
 
 	c.fonts = fontMap
+	c.fallbacks = fallbacks
 	return nil
 }
 
-// SetFont sets the font for the context
-func (c *context) setFont(font string) {
-	if f, ok := c.fonts[font]; ok {
-		c.SetFont(f)
+// WriteString draws a string onto the image using the specified parameters. Glyphs missing from
+// the font are drawn with the first fallback font that has them.
+func (c *context) writeString(font string, size float64, src image.Image, text string, point fixed.Point26_6) error {
+	f, ok := c.fonts[font]
+	if !ok {
+		return fmt.Errorf("font %q not loaded", font)
 	}
-}
 
-// WriteString draws a string onto the image using the specified parameters
-func (c *context) writeString(font string, size float64, src image.Image, text string, point fixed.Point26_6) error {
-	c.setFont(font)
 	c.SetFontSize(size)
 	c.SetSrc(src)
-	_, err := c.DrawString(text, point)
-	return err
+
+	var err error
+	for _, run := range splitRuns(f, c.fallbacks, text) {
+		c.SetFont(run.font)
+		point, err = c.DrawString(run.text, point)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measure returns the width of a string as drawn by writeString
+func (c *context) measure(font string, size float64, text string) (fixed.Int26_6, error) {
+	f, ok := c.fonts[font]
+	if !ok {
+		return 0, fmt.Errorf("font %q not loaded", font)
+	}
+
+	var width fixed.Int26_6
+	for _, run := range splitRuns(f, c.fallbacks, text) {
+		face := truetype.NewFace(run.font, &truetype.Options{Size: size})
+		width += xfont.MeasureString(face, run.text)
+	}
+	return width, nil
+}
+
+// extent is the size of a line of text: its width, and how far the font reaches above and below
+// the baseline
+type extent struct {
+	width, ascent, descent fixed.Int26_6
+}
+
+// vertical returns how far a font reaches above and below the baseline at a size. Fallback
+// fonts aren't included, so a line's extent doesn't change with the glyphs in it.
+func (c *context) vertical(font string, size float64) (fixed.Int26_6, fixed.Int26_6, error) {
+	f, ok := c.fonts[font]
+	if !ok {
+		return 0, 0, fmt.Errorf("font %q not loaded", font)
+	}
+
+	m := truetype.NewFace(f, &truetype.Options{Size: size}).Metrics()
+	return m.Ascent, m.Descent, nil
 }
 
-// Generate creates and returns an image based on the provided document data
-func Generate(doc database.Document) ([]byte, error) {
-	width, height := 1080, 1080
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
+// fit returns the size to draw text at so it is no wider than the text's MaxWidth and no taller
+// than its MaxHeight, along with its extent at that size
+func (c *context) fit(text Text, value string) (float64, extent, error) {
+	size := text.Size
+	ascent, descent, err := c.vertical(text.Font, size)
+	if err != nil {
+		return size, extent{}, err
+	}
+
+	// Height is proportional to size, so it fits in one step
+	if limit := fixed.I(text.MaxHeight); text.MaxHeight > 0 && ascent+descent > limit {
+		size = math.Max(text.Size*minFitScale, size*float64(limit)/float64(ascent+descent))
+	}
+
+	width, err := c.measure(text.Font, size, value)
+	if err != nil {
+		return size, extent{}, err
+	}
+
+	// Width is close to proportional to size, so one scale usually fits. Kerning and rounding
+	// can leave it a little over, so keep shrinking, but not past minFitScale.
+	if text.MaxWidth > 0 {
+		limit := fixed.I(text.MaxWidth)
+		for width > limit && size > text.Size*minFitScale {
+			size = math.Max(text.Size*minFitScale, size*math.Min(0.95, float64(limit)/float64(width)))
+			width, err = c.measure(text.Font, size, value)
+			if err != nil {
+				return size, extent{}, err
+			}
+		}
+	}
+
+	ascent, descent, err = c.vertical(text.Font, size)
+	return size, extent{width: width, ascent: ascent, descent: descent}, err
+}
+
+// baseline returns the y of the baseline that puts the text's VAlign at its Y
+func baseline(text Text, e extent) fixed.Int26_6 {
+	y := fixed.I(text.Y)
+	switch text.VAlign {
+	case VAlignTop:
+		y += e.ascent
+	case VAlignMiddle:
+		y += (e.ascent - e.descent) / 2
+	case VAlignBottom:
+		y -= e.descent
+	}
+	return y
+}
+
+// place returns where a line of text starts, given its extent, so that it is aligned to the
+// text's X and Y. Right to left layouts mirror the line across the canvas.
+func place(t *Template, text Text, e extent, rtl bool) fixed.Point26_6 {
+	start := fixed.I(text.X)
+	switch text.Align {
+	case AlignCentre:
+		start -= e.width / 2
+	case AlignRight:
+		start -= e.width
+	}
+	if rtl {
+		start = fixed.I(t.Width) - start - e.width
+	}
+	return fixed.Point26_6{X: start, Y: baseline(text, e)}
+}
+
+// Generate creates and returns an image of the document data laid out by the template, encoded
+// in the given format
+func Generate(t *Template, data Data, format Format) ([]byte, error) {
+	if format == FormatSVG {
+		return renderSVG(t, data)
+	}
+
+	img, err := rasterise(t, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(img, format)
+}
+
+// rasterise draws the template onto a new image
+func rasterise(t *Template, data Data) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, t.Width, t.Height))
 
 	// Set background color
-	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	background, err := data.Palette.colour(t.Background)
+	if err != nil {
+		return nil, err
+	}
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
 
 	// Initialize freetype context and load fonts
 	c := newContext()
-	err := c.loadFonts("Roboto-Regular.ttf", "CarterOne-Regular.ttf")
+	err = c.loadFonts(t.fonts()...)
 	if err != nil {
 		return nil, err
 	}
@@ -93,61 +218,45 @@ func Generate(doc database.Document) ([]byte, error) {
 	c.SetClip(img.Bounds())
 	c.SetDst(img)
 
-	// Colors
-	lightGrey := image.NewUniform(color.RGBA{R: 233, G: 233, B: 233, A: 255})
-	red := image.NewUniform(color.RGBA{R: 201, G: 8, B: 79, A: 255})
-
-	// Draw rectangles on the image
-	rectangles := []image.Rectangle{
-		{Min: image.Point{0, 0}, Max: image.Point{1080, 280}},
-		{Min: image.Point{80, 400}, Max: image.Point{500, 650}},
-		{Min: image.Point{80, 750}, Max: image.Point{500, 1000}},
-		{Min: image.Point{580, 400}, Max: image.Point{1000, 650}},
-		{Min: image.Point{580, 750}, Max: image.Point{1000, 1000}},
-	}
-	for _, r := range rectangles {
-		draw.Draw(img, r, lightGrey, image.Pt(0, 0), draw.Src)
+	// Draw panels on the image
+	for _, p := range t.Panels {
+		if !data.panelShown(p) {
+			continue
+		}
+		colour, err := data.Palette.colour(p.Colour)
+		if err != nil {
+			return nil, err
+		}
+		r := image.Rect(p.X, p.Y, p.X+p.Width, p.Y+p.Height)
+		if data.Locale.RTL {
+			r = image.Rect(t.Width-r.Max.X, r.Min.Y, t.Width-r.Min.X, r.Max.Y)
+		}
+		draw.Draw(img, r, image.NewUniform(colour), image.Point{}, draw.Src)
 	}
 
 	// Draw text onto the image
-	texts := []struct {
-		font  string
-		size  float64
-		src   image.Image
-		text  string
-		point fixed.Point26_6
-	}{
-		// Title fonts
-		{"CarterOne-Regular.ttf", 120, image.Black, fmt.Sprintf("Daily Update"), freetype.Pt(20, 125)},
-		{"CarterOne-Regular.ttf", 120, image.Black, doc.Title, freetype.Pt(325, 250)},
-		{"Roboto-Regular.ttf", 64, image.Black, fmt.Sprintf("Weight"), freetype.Pt(80, 390)},
-		{"Roboto-Regular.ttf", 64, image.Black, fmt.Sprintf("Intake"), freetype.Pt(580, 390)},
-		{"Roboto-Regular.ttf", 64, image.Black, fmt.Sprintf("Active Energy"), freetype.Pt(80, 740)},
-		{"Roboto-Regular.ttf", 64, image.Black, fmt.Sprintf("Resting Energy"), freetype.Pt(580, 740)},
-		// Units
-		{"Roboto-Regular.ttf", 72, image.Black, "kg", freetype.Pt(400, 625)},
-		{"Roboto-Regular.ttf", 72, image.Black, "kJ", freetype.Pt(900, 625)},
-		{"Roboto-Regular.ttf", 72, image.Black, "kJ", freetype.Pt(400, 975)},
-		{"Roboto-Regular.ttf", 72, image.Black, "kJ", freetype.Pt(900, 975)},
-		// Qty
-		{"CarterOne-Regular.ttf", 108, red, fmt.Sprintf("%.1f", doc.Weight), freetype.Pt(100, 525)},
-		{"CarterOne-Regular.ttf", 108, red, fmt.Sprintf("%.0f", doc.IntakeEnergy), freetype.Pt(600, 525)},
-		{"CarterOne-Regular.ttf", 108, red, fmt.Sprintf("%.0f", doc.ActiveEnergy), freetype.Pt(100, 875)},
-		{"CarterOne-Regular.ttf", 108, red, fmt.Sprintf("%.0f", doc.RestingEnergy), freetype.Pt(600, 875)},
-	}
-	for _, text := range texts {
-		err = c.writeString(text.font, text.size, text.src, text.text, text.point)
+	for _, text := range t.Texts {
+		value, ok := data.resolve(text)
+		if !ok {
+			continue
+		}
+		colour, err := data.textColour(text)
 		if err != nil {
 			return nil, err
 		}
-	}
 
-	// Encode the image to JPEG format
-	var buf bytes.Buffer
-	err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
-	if err != nil {
-		return nil, err
+		value = data.Locale.visual(value)
+		size, e, err := c.fit(text, value)
+		if err != nil {
+			return nil, err
+		}
+
+		point := place(t, text, e, data.Locale.RTL)
+		err = c.writeString(text.Font, size, image.NewUniform(colour), value, point)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return buf.Bytes(), nil
+	return img, nil
 }
@@ -0,0 +1,69 @@
+package image
+
+import (
+	"testing"
+
+	"golang.org/x/image/math/fixed"
+)
+
+func TestFit(t *testing.T) {
+	c := newContext()
+	if err := c.loadFonts(DefaultFont); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		text  Text
+		value string
+	}{
+		{"unbounded", Text{Size: 96}, "8,856"},
+		{"max width", Text{Size: 96, MaxWidth: 200}, "88,856"},
+		{"max height", Text{Size: 96, MaxHeight: 60}, "108.1"},
+		{"max width and height", Text{Size: 96, MaxWidth: 150, MaxHeight: 80}, "108.1"},
+		{"already fits", Text{Size: 40, MaxWidth: 400, MaxHeight: 400}, "7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.text.Font = DefaultFont
+			size, e, err := c.fit(tt.text, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > tt.text.Size || size < tt.text.Size*minFitScale {
+				t.Errorf("size %g outside %g to %g", size, tt.text.Size*minFitScale, tt.text.Size)
+			}
+			if tt.text.MaxWidth > 0 && e.width > fixed.I(tt.text.MaxWidth) {
+				t.Errorf("width %v over %d", e.width, tt.text.MaxWidth)
+			}
+			if tt.text.MaxHeight > 0 && e.ascent+e.descent > fixed.I(tt.text.MaxHeight) {
+				t.Errorf("height %v over %d", e.ascent+e.descent, tt.text.MaxHeight)
+			}
+			if tt.text.MaxWidth == 0 && tt.text.MaxHeight == 0 && size != tt.text.Size {
+				t.Errorf("unbounded text drawn at %g, want %g", size, tt.text.Size)
+			}
+		})
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	e := extent{width: fixed.I(100), ascent: fixed.I(30), descent: fixed.I(10)}
+
+	tests := []struct {
+		valign string
+		want   int
+	}{
+		{"", 200},
+		{VAlignBaseline, 200},
+		{VAlignTop, 230},
+		{VAlignMiddle, 210},
+		{VAlignBottom, 190},
+	}
+
+	for _, tt := range tests {
+		if got := baseline(Text{Y: 200, VAlign: tt.valign}, e); got != fixed.I(tt.want) {
+			t.Errorf("baseline for valign %q is %v, want %d", tt.valign, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package image
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/util/image/imagediff"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden images instead of comparing")
+
+const (
+	goldenDir = "testdata/golden"
+
+	// goldenThreshold is the perceptual difference, from 0 to 1, at which a pixel differs
+	goldenThreshold = 0.1
+	// goldenTolerance is the fraction of pixels allowed to differ
+	goldenTolerance = 0.001
+)
+
+// fixtureEnd is the last day of the fixture series, and the day every card is rendered for
+var fixtureEnd = time.Date(2023, 5, 28, 0, 0, 0, 0, time.UTC)
+
+// goldenCase is one golden image
+type goldenCase struct {
+	name   string
+	render func(docs []database.Document) ([]byte, error)
+}
+
+// goldenCases are the golden images. Palettes are named explicitly, as "auto" depends on the
+// clock.
+var goldenCases = []goldenCase{
+	cardCase("card-light", SizeSquare, database.Settings{Palette: PaletteLight, ShowStreak: true, IntakeTarget: 8500}),
+	cardCase("card-dark", SizeSquare, database.Settings{Palette: PaletteDark, IntakeTarget: 8500}),
+	cardCase("card-story", SizeStory, database.Settings{Palette: PaletteLight}),
+	cardCase("card-link", SizeLink, database.Settings{Palette: PaletteLight}),
+	cardCase("card-privacy", SizeSquare, database.Settings{Palette: PaletteLight, Privacy: string(PrivacyChange), HiddenFields: []string{"IntakeEnergy"}}),
+	cardCase("card-de", SizeSquare, database.Settings{Palette: PaletteLight, Locale: "de", ShowStreak: true}),
+	cardCase("card-he", SizeSquare, database.Settings{Palette: PaletteLight, Locale: "he"}),
+	summaryCase("summary-light", database.Settings{Palette: PaletteLight, ShowStreak: true, IntakeTarget: 8500}),
+	summaryCase("summary-he-dark", database.Settings{Palette: PaletteDark, Locale: "he"}),
+	chartCase("chart-7d", 7, database.Settings{Palette: PaletteLight}),
+	chartCase("chart-30d-dark", 30, database.Settings{Palette: PaletteDark, Locale: "fr"}),
+}
+
+// TestGolden renders cards and charts from fixed data and compares them with the golden images
+// under testdata using a perceptual diff, writing the render and a diff of any mismatch to the
+// temp directory. Run with -update to regenerate the goldens after an intended layout change.
+//
+// Renders only use the built-in templates and bundled fonts, so they don't depend on what's
+// uploaded to the bucket. A template font that isn't bundled is drawn with its substitute and
+// logged, so goldens made that way are known not to match what gets posted.
+func TestGolden(t *testing.T) {
+	DisableRemoteFonts()
+	for name, substitute := range fontSubstitutes {
+		if _, err := bundledFonts.ReadFile("fonts/" + name); err != nil {
+			t.Logf("%s isn't bundled, so it is drawn with %s; run go generate, then -update", name, substitute)
+		}
+	}
+
+	docs := fixtureDocuments()
+	for _, c := range goldenCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			b, err := c.render(docs)
+			if err != nil {
+				t.Fatalf("rendering: %v", err)
+			}
+			actual, _, err := image.Decode(bytes.NewReader(b))
+			if err != nil {
+				t.Fatalf("decoding render: %v", err)
+			}
+
+			golden := filepath.Join(goldenDir, c.name+".png")
+			if *update {
+				if err = writePNG(golden, actual); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			f, err := os.Open(golden)
+			if err != nil {
+				t.Fatalf("no golden image, run with -update: %v", err)
+			}
+			defer f.Close()
+			expected, err := png.Decode(f)
+			if err != nil {
+				t.Fatalf("decoding golden image: %v", err)
+			}
+
+			result, err := imagediff.Compare(expected, actual, goldenThreshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Ratio() <= goldenTolerance {
+				return
+			}
+
+			out := filepath.Join(os.TempDir(), "golden")
+			rendered := filepath.Join(out, c.name+".png")
+			diff := filepath.Join(out, c.name+"-diff.png")
+			if err = writePNG(rendered, actual); err != nil {
+				t.Fatal(err)
+			}
+			if err = writePNG(diff, result.Diff); err != nil {
+				t.Fatal(err)
+			}
+			t.Errorf("%d of %d pixels differ (%.3f%%), see %s and %s; run with -update to accept the new render", result.Different, result.Pixels, 100*result.Ratio(), rendered, diff)
+		})
+	}
+}
+
+// fixtureDocuments returns 60 days of made up logs. Values follow fixed curves, not random
+// numbers, so every run renders the same images. A missed day and an outlier weigh-in exercise
+// the gaps and outlier handling.
+func fixtureDocuments() []database.Document {
+	docs := make([]database.Document, 0, 60)
+	for d := 0; d < 60; d++ {
+		date := fixtureEnd.AddDate(0, 0, d-59)
+		if d == 40 {
+			continue
+		}
+
+		weight := 112 - 0.06*float64(d) + 0.4*math.Sin(float64(d)/2)
+		if d == 50 {
+			weight += 6
+		}
+
+		docs = append(docs, database.Document{
+			Title:         date.Format("2006-01-02"),
+			Weight:        math.Round(weight*10) / 10,
+			IntakeEnergy:  math.Round(8200 + 900*math.Sin(float64(d)/3)),
+			ActiveEnergy:  math.Round(2400 + 600*math.Cos(float64(d)/4)),
+			RestingEnergy: 8900,
+		})
+	}
+	return docs
+}
+
+// cardCase renders the daily card for the last fixture day. Size variants are looked up among
+// the built-in templates only.
+func cardCase(name string, size Size, settings database.Settings) goldenCase {
+	return goldenCase{
+		name: name,
+		render: func(docs []database.Document) ([]byte, error) {
+			t, err := BuiltinTemplate(PostTypeDaily)
+			if err != nil {
+				return nil, err
+			}
+			data := NewData(docs[len(docs)-1], docs, settings)
+			variant, err := variantFor(t, size, BuiltinTemplate)
+			if err != nil {
+				return nil, err
+			}
+			return Generate(variant, data, FormatPNG)
+		},
+	}
+}
+
+// summaryCase renders the weekly summary card for the week ending on the last fixture day
+func summaryCase(name string, settings database.Settings) goldenCase {
+	return goldenCase{
+		name: name,
+		render: func(docs []database.Document) ([]byte, error) {
+			t, err := BuiltinTemplate(PostTypeSummary)
+			if err != nil {
+				return nil, err
+			}
+			data := NewSummaryData(docs, fixtureEnd.Format("2006-01-02"), 7, settings)
+			return Generate(t, data, FormatPNG)
+		},
+	}
+}
+
+// chartCase renders the chart of the given number of days up to the last fixture day
+func chartCase(name string, days int, settings database.Settings) goldenCase {
+	return goldenCase{
+		name: name,
+		render: func(docs []database.Document) ([]byte, error) {
+			style := StyleFor(settings, fixtureEnd)
+			return GenerateChart(docs, fixtureEnd.Format("2006-01-02"), days, style)
+		},
+	}
+}
+
+func writePNG(name string, img image.Image) error {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return fmt.Errorf("error encoding %s: %v", name, err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, buf.Bytes(), 0o644)
+}
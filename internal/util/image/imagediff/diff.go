@@ -0,0 +1,90 @@
+// Package imagediff compares rendered images by how different they look rather than by their bytes
+package imagediff
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// maxDelta is the largest possible YIQ delta, between black and white
+const maxDelta = 35215
+
+// Result is the outcome of comparing two images
+type Result struct {
+	Pixels    int
+	Different int
+	// Diff is a faded copy of the expected image with differing pixels in red
+	Diff *image.RGBA
+}
+
+// Ratio returns the fraction of pixels that differ
+func (r Result) Ratio() float64 {
+	if r.Pixels == 0 {
+		return 0
+	}
+	return float64(r.Different) / float64(r.Pixels)
+}
+
+// Compare compares two images of the same size. A pixel differs when its perceptual colour
+// difference is more than threshold, from 0 for an exact match to 1 for black against white.
+func Compare(expected, actual image.Image, threshold float64) (Result, error) {
+	eb, ab := expected.Bounds(), actual.Bounds()
+	if eb.Dx() != ab.Dx() || eb.Dy() != ab.Dy() {
+		return Result{}, fmt.Errorf("size %dx%d doesn't match %dx%d", ab.Dx(), ab.Dy(), eb.Dx(), eb.Dy())
+	}
+
+	limit := maxDelta * threshold * threshold
+	r := Result{
+		Pixels: eb.Dx() * eb.Dy(),
+		Diff:   image.NewRGBA(image.Rect(0, 0, eb.Dx(), eb.Dy())),
+	}
+
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			e := expected.At(eb.Min.X+x, eb.Min.Y+y)
+			a := actual.At(ab.Min.X+x, ab.Min.Y+y)
+
+			if delta(e, a) > limit {
+				r.Different++
+				r.Diff.Set(x, y, color.RGBA{R: 255, A: 255})
+				continue
+			}
+
+			// Matching pixels are drawn as a pale grey version of the expected image
+			l := 255 - uint8(0.1*(255-luma(e)))
+			r.Diff.Set(x, y, color.RGBA{R: l, G: l, B: l, A: 255})
+		}
+	}
+
+	return r, nil
+}
+
+// delta is the squared YIQ distance between two colours, blended onto white, which tracks how
+// different they look better than RGB distance does
+func delta(a, b color.Color) float64 {
+	ay, ai, aq := yiq(a)
+	by, bi, bq := yiq(b)
+	dy, di, dq := ay-by, ai-bi, aq-bq
+	return 0.5053*dy*dy + 0.299*di*di + 0.1957*dq*dq
+}
+
+func yiq(c color.Color) (float64, float64, float64) {
+	r, g, b := blend(c)
+	y := 0.29889531*r + 0.58662247*g + 0.11448223*b
+	i := 0.59597799*r - 0.27417610*g - 0.32180189*b
+	q := 0.21147017*r - 0.52261711*g + 0.31114694*b
+	return y, i, q
+}
+
+func luma(c color.Color) float64 {
+	y, _, _ := yiq(c)
+	return y
+}
+
+// blend returns the colour composited onto white, in 0-255 channels
+func blend(c color.Color) (float64, float64, float64) {
+	r, g, b, a := c.RGBA()
+	white := float64(0xffff - a)
+	return (float64(r) + white) / 257, (float64(g) + white) / 257, (float64(b) + white) / 257
+}
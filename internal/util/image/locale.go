@@ -0,0 +1,238 @@
+package image
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/unicode/bidi"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+// DefaultLocale is the locale used when none is set, and for labels missing from a catalogue
+const DefaultLocale = "en"
+
+//go:embed locales/*.json
+var builtinLocales embed.FS
+
+// Locale is a catalogue of labels with the date and number conventions of a language
+type Locale struct {
+	Name string `json:"name"`
+	// RTL mirrors the card layout and lays text out right to left
+	RTL     bool   `json:"rtl,omitempty"`
+	Decimal string `json:"decimal"`
+	Group   string `json:"group"`
+	// Date is the date layout, with {weekday}, {day} and {month} replaced
+	Date     string            `json:"date"`
+	Weekdays []string          `json:"weekdays"`
+	Months   []string          `json:"months"`
+	Labels   map[string]string `json:"labels"`
+}
+
+var locales struct {
+	sync.Once
+	byName map[string]Locale
+	err    error
+}
+
+// numberVerb matches the float verb in a template format, capturing the sign flag and precision
+var numberVerb = regexp.MustCompile(`%(\+?)(?:\.(\d+))?f`)
+
+// LocaleByName returns the built-in locale with the given name
+func LocaleByName(name string) (Locale, error) {
+	locales.Do(func() {
+		locales.byName, locales.err = loadLocales()
+	})
+	if locales.err != nil {
+		return Locale{}, locales.err
+	}
+
+	l, ok := locales.byName[name]
+	if !ok {
+		return Locale{}, fmt.Errorf("locale %q not found", name)
+	}
+	return l, nil
+}
+
+func loadLocales() (map[string]Locale, error) {
+	entries, err := builtinLocales.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]Locale)
+	for _, entry := range entries {
+		b, err := builtinLocales.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		var l Locale
+		err = json.Unmarshal(b, &l)
+		if err != nil {
+			return nil, fmt.Errorf("locale %s: %v", entry.Name(), err)
+		}
+		if len(l.Weekdays) != 7 || len(l.Months) != 12 {
+			return nil, fmt.Errorf("locale %q: needs 7 weekdays and 12 months", l.Name)
+		}
+		byName[l.Name] = l
+	}
+
+	if _, ok := byName[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("default locale %q missing", DefaultLocale)
+	}
+	return byName, nil
+}
+
+// LocaleFor returns the locale the user has chosen for images
+func LocaleFor(settings database.Settings) Locale {
+	name := settings.Locale
+	if name == "" {
+		name = DefaultLocale
+	}
+
+	l, err := LocaleByName(name)
+	if err != nil {
+		fmt.Printf("error loading locale '%s', using %s: %v\n", name, DefaultLocale, err)
+		l = defaultLocale()
+	}
+	return l
+}
+
+// defaultLocale returns the default locale. It is embedded, so only a broken build can fail to
+// load it.
+func defaultLocale() Locale {
+	l, _ := LocaleByName(DefaultLocale)
+	return l
+}
+
+// orDefault returns the locale, or the default locale if it is unset
+func (l Locale) orDefault() Locale {
+	if l.Name != "" {
+		return l
+	}
+	return defaultLocale()
+}
+
+// hasLabel reports whether the default catalogue defines the label
+func hasLabel(key string) bool {
+	_, ok := defaultLocale().Labels[key]
+	return ok
+}
+
+// label returns the translation of a label, falling back to the default catalogue
+func (l Locale) label(key string) string {
+	if s, ok := l.orDefault().Labels[key]; ok {
+		return s
+	}
+	if s, ok := defaultLocale().Labels[key]; ok {
+		return s
+	}
+	return key
+}
+
+// number formats v with the given decimal places, grouping thousands. plus adds a sign to
+// positive numbers.
+func (l Locale) number(v float64, decimals int, plus bool) string {
+	l = l.orDefault()
+
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	switch {
+	case v < 0 && strings.Trim(s, "0.") != "":
+		b.WriteString("-")
+	case plus:
+		b.WriteString("+")
+	}
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(l.Group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(l.Decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// sprintf formats a number with a printf style format, swapping the %f verb for a localised number
+func (l Locale) sprintf(format string, v float64) string {
+	m := numberVerb.FindStringSubmatchIndex(format)
+	if m == nil {
+		return fmt.Sprintf(format, v)
+	}
+
+	decimals := 6
+	if m[4] >= 0 {
+		decimals, _ = strconv.Atoi(format[m[4]:m[5]])
+	}
+	plus := m[3] > m[2]
+
+	n := l.number(v, decimals, plus)
+	return strings.ReplaceAll(format[:m[0]], "%%", "%") + n + strings.ReplaceAll(format[m[1]:], "%%", "%")
+}
+
+// date formats a date as a short weekday, day and month
+func (l Locale) date(t time.Time) string {
+	l = l.orDefault()
+	return strings.NewReplacer(
+		"{weekday}", l.Weekdays[t.Weekday()],
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", l.Months[t.Month()-1],
+	).Replace(l.Date)
+}
+
+// visual returns text in the order its glyphs are drawn. In a right to left locale the text is
+// reordered with the Unicode bidirectional algorithm, so numbers and Latin text inside it keep
+// their order. Text without right to left letters, such as a value, is drawn as it is.
+func (l Locale) visual(text string) string {
+	if !l.RTL || !hasRTL(text) {
+		return text
+	}
+
+	var p bidi.Paragraph
+	_, err := p.SetString(text, bidi.DefaultDirection(bidi.RightToLeft))
+	if err != nil {
+		return text
+	}
+	o, err := p.Order()
+	if err != nil {
+		return text
+	}
+
+	// Labels have no explicit embeddings, so a right to left paragraph only has right to left
+	// runs and the left to right runs between them. Drawn, the runs go in reverse and the right
+	// to left runs are reversed, mirroring brackets.
+	var sb strings.Builder
+	for i := o.NumRuns() - 1; i >= 0; i-- {
+		run := o.Run(i)
+		if run.Direction() == bidi.RightToLeft {
+			sb.WriteString(bidi.ReverseString(run.String()))
+			continue
+		}
+		sb.WriteString(run.String())
+	}
+
+	return sb.String()
+}
+
+// hasRTL reports whether text has a strongly right to left character
+func hasRTL(text string) bool {
+	for _, r := range text {
+		p, _ := bidi.LookupRune(r)
+		if c := p.Class(); c == bidi.R || c == bidi.AL {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package image
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// PaletteLight is the default palette, dark text on white
+	PaletteLight = "light"
+	// PaletteDark is light text on a near-black background
+	PaletteDark = "dark"
+	// PaletteAuto picks light or dark by the time of day
+	PaletteAuto = "auto"
+
+	// darkFrom and darkUntil are the hours between which PaletteAuto is dark
+	darkFrom  = 18
+	darkUntil = 6
+)
+
+// Palette maps colour roles to colours. Template colours can name a role instead of a hex colour.
+type Palette map[string]string
+
+// paletteRoles are the roles every palette defines
+var paletteRoles = []string{"background", "panel", "text", "accent", "muted", "highlight", "good", "bad"}
+
+var palettes = map[string]Palette{
+	PaletteLight: {
+		"background": "#ffffff",
+		"panel":      "#e9e9e9",
+		"text":       "#000000",
+		"accent":     "#c9084f",
+		"muted":      "#969696",
+		"highlight":  "#e996b4",
+		"good":       "#1e9e4a",
+		"bad":        "#d62828",
+	},
+	PaletteDark: {
+		"background": "#121212",
+		"panel":      "#2a2a2a",
+		"text":       "#f0f0f0",
+		"accent":     "#ff4f8b",
+		"muted":      "#8a8a8a",
+		"highlight":  "#7a3b55",
+		"good":       "#3fbf6f",
+		"bad":        "#ff5a5a",
+	},
+}
+
+// PaletteFor returns the palette the user has chosen, at the given local time for PaletteAuto.
+// Roles missing from a custom palette are taken from the light palette.
+func PaletteFor(settings database.Settings, now time.Time) Palette {
+	name := settings.Palette
+	if name == PaletteAuto {
+		name = PaletteLight
+		if h := now.Hour(); h >= darkFrom || h < darkUntil {
+			name = PaletteDark
+		}
+	}
+
+	if p, ok := palettes[name]; ok {
+		return p
+	}
+
+	custom, ok := settings.Palettes[name]
+	if !ok {
+		if name != "" {
+			fmt.Printf("unknown palette '%s', using %s\n", name, PaletteLight)
+		}
+		return palettes[PaletteLight]
+	}
+
+	p := make(Palette, len(paletteRoles))
+	for _, role := range paletteRoles {
+		p[role] = palettes[PaletteLight][role]
+		c, ok := custom[role]
+		if !ok {
+			continue
+		}
+		if _, err := parseHex(c); err != nil {
+			fmt.Printf("palette '%s': %s: %v\n", name, role, err)
+			continue
+		}
+		p[role] = c
+	}
+	return p
+}
+
+// colour resolves a template colour, either a role name or a hex colour
+func (p Palette) colour(s string) (color.NRGBA, error) {
+	if strings.HasPrefix(s, "#") {
+		return parseHex(s)
+	}
+	if !isRole(s) {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q", s)
+	}
+
+	if c, ok := p[s]; ok {
+		return parseHex(c)
+	}
+	return parseHex(palettes[PaletteLight][s])
+}
+
+// role returns the colour of a palette role
+func (p Palette) role(name string) color.NRGBA {
+	c, _ := p.colour(name)
+	return c
+}
+
+func isRole(s string) bool {
+	for _, role := range paletteRoles {
+		if role == s {
+			return true
+		}
+	}
+	return false
+}
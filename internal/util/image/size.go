@@ -0,0 +1,121 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// VariantFilenameFormat is the object name for a non-square variant, formatted with the date,
+	// size name and extension
+	VariantFilenameFormat = "weightlog/%s-%s.%s"
+)
+
+// Size is an output canvas size
+type Size struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var (
+	SizeSquare   = Size{Name: "square", Width: 1080, Height: 1080}
+	SizeStory    = Size{Name: "story", Width: 1080, Height: 1920}
+	SizePortrait = Size{Name: "portrait", Width: 1080, Height: 1350}
+	SizeLink     = Size{Name: "link", Width: 1200, Height: 630}
+)
+
+// Sizes lists every size a card is rendered at
+var Sizes = []Size{SizeSquare, SizeStory, SizePortrait, SizeLink}
+
+// SizeByName returns the size with the given name
+func SizeByName(name string) (Size, bool) {
+	for _, size := range Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return Size{}, false
+}
+
+// Filename returns the object name of a card for the date at the given size and format. The
+// square card keeps the original FilenameFormat.
+func Filename(date string, size Size, format Format) string {
+	if size == SizeSquare {
+		return fmt.Sprintf(FilenameFormat, date, format.Extension())
+	}
+	return fmt.Sprintf(VariantFilenameFormat, date, size.Name, format.Extension())
+}
+
+// VariantFor returns the template used for a size. A template named "<name>-<size>" is used if
+// one exists, otherwise the template is scaled to fit. A variant that exists but can't be loaded
+// is an error rather than quietly replaced by scaling.
+func VariantFor(t *Template, size Size) (*Template, error) {
+	return variantFor(t, size, LoadTemplate)
+}
+
+// variantFor is VariantFor looking templates up with load, so renders can be kept to the
+// built-in templates
+func variantFor(t *Template, size Size, load func(name string) (*Template, error)) (*Template, error) {
+	if t.Width == size.Width && t.Height == size.Height {
+		return t, nil
+	}
+	v, err := load(fmt.Sprintf("%s-%s", t.Name, size.Name))
+	if errors.Is(err, errNoTemplate) {
+		return t.Scaled(size.Width, size.Height), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Scaled returns a copy of the template fitted to a new canvas. Text and panels are scaled
+// uniformly and centred, and panels that touch an edge of the original canvas are stretched
+// to the same edge of the new one so full-bleed bands stay full-bleed.
+func (t *Template) Scaled(width, height int) *Template {
+	scale := math.Min(float64(width)/float64(t.Width), float64(height)/float64(t.Height))
+	offX := (float64(width) - scale*float64(t.Width)) / 2
+	offY := (float64(height) - scale*float64(t.Height)) / 2
+
+	x := func(v int) int { return int(math.Round(offX + scale*float64(v))) }
+	y := func(v int) int { return int(math.Round(offY + scale*float64(v))) }
+
+	scaled := &Template{
+		Name:       t.Name,
+		Width:      width,
+		Height:     height,
+		Background: t.Background,
+		Panels:     make([]Panel, len(t.Panels)),
+		Texts:      make([]Text, len(t.Texts)),
+	}
+
+	for i, p := range t.Panels {
+		minX, minY, maxX, maxY := x(p.X), y(p.Y), x(p.X+p.Width), y(p.Y+p.Height)
+		if p.X <= 0 {
+			minX = 0
+		}
+		if p.Y <= 0 {
+			minY = 0
+		}
+		if p.X+p.Width >= t.Width {
+			maxX = width
+		}
+		if p.Y+p.Height >= t.Height {
+			maxY = height
+		}
+		p.X, p.Y, p.Width, p.Height = minX, minY, maxX-minX, maxY-minY
+		scaled.Panels[i] = p
+	}
+
+	for i, text := range t.Texts {
+		text.X, text.Y = x(text.X), y(text.Y)
+		text.Size *= scale
+		text.MaxWidth = int(math.Round(scale * float64(text.MaxWidth)))
+		text.MaxHeight = int(math.Round(scale * float64(text.MaxHeight)))
+		scaled.Texts[i] = text
+	}
+
+	return scaled
+}
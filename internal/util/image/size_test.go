@@ -0,0 +1,50 @@
+package image
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestVariantFor(t *testing.T) {
+	daily, err := BuiltinTemplate(PostTypeDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+	story := &Template{Name: "daily-story", Width: SizeStory.Width, Height: SizeStory.Height}
+
+	tests := []struct {
+		name    string
+		load    func(name string) (*Template, error)
+		want    string
+		wantErr bool
+	}{
+		{"variant", func(string) (*Template, error) { return story, nil }, "daily-story", false},
+		{"no variant", func(name string) (*Template, error) {
+			return nil, fmt.Errorf("template %q: %w", name, errNoTemplate)
+		}, "daily", false},
+		{"broken variant", func(string) (*Template, error) {
+			return ParseTemplate([]byte("{"))
+		}, "", true},
+		{"unreadable variant", func(string) (*Template, error) {
+			return nil, fmt.Errorf("bucket unavailable")
+		}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := variantFor(daily, SizeStory, tt.load)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("returned %q, want an error", v.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Name != tt.want || v.Width != SizeStory.Width || v.Height != SizeStory.Height {
+				t.Errorf("returned %q at %dx%d, want %q at %dx%d", v.Name, v.Width, v.Height, tt.want, SizeStory.Width, SizeStory.Height)
+			}
+		})
+	}
+}
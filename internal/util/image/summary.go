@@ -0,0 +1,88 @@
+package image
+
+import (
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// PostTypeSummary is the post type of the weekly summary card
+	PostTypeSummary = "summary"
+
+	// SummaryFilenameFormat is the object name for a summary card, formatted with the end date and
+	// number of days
+	SummaryFilenameFormat = "weightlog/summary/%s-%dd.jpg"
+)
+
+// Week summarises the days up to a summary card's date
+type Week struct {
+	// Start is the first day summarised, in "2006-01-02" form
+	Start string
+	Days  int
+	// Logged is the number of days with a weigh-in
+	Logged int
+	// Averages are over the days with a value logged
+	AverageIntake  float64
+	AverageActive  float64
+	AverageResting float64
+}
+
+// NewSummaryData builds the summary card for the days up to and including end, with progress
+// computed from the full document series
+func NewSummaryData(docs []database.Document, end string, days int, settings database.Settings) Data {
+	doc := database.Document{Title: end}
+	for _, d := range docs {
+		if d.Title == end {
+			doc = d
+		}
+	}
+
+	data := NewData(doc, docs, settings)
+	data.Week = summarise(docs, end, days)
+	return data
+}
+
+func summarise(docs []database.Document, end string, days int) Week {
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return Week{}
+	}
+	week := Week{Start: endDate.AddDate(0, 0, -days+1).Format("2006-01-02"), Days: days}
+
+	var intake, active, resting average
+	for _, d := range docs {
+		if d.Title < week.Start || d.Title > end {
+			continue
+		}
+		if d.Weight > 0 {
+			week.Logged++
+		}
+		intake.add(d.IntakeEnergy)
+		active.add(d.ActiveEnergy)
+		resting.add(d.RestingEnergy)
+	}
+	week.AverageIntake, week.AverageActive, week.AverageResting = intake.value(), active.value(), resting.value()
+
+	return week
+}
+
+// average is the mean of the non-zero values added
+type average struct {
+	sum float64
+	n   int
+}
+
+func (a *average) add(v float64) {
+	if v > 0 {
+		a.sum += v
+		a.n++
+	}
+}
+
+func (a average) value() float64 {
+	if a.n == 0 {
+		return 0
+	}
+	return a.sum / float64(a.n)
+}
@@ -0,0 +1,119 @@
+package image
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+// fontFamilies maps font files to the family name used in SVG output
+var fontFamilies = map[string]string{
+	"Roboto-Regular.ttf":    "Roboto",
+	"CarterOne-Regular.ttf": "Carter One",
+	"Go-Regular.ttf":        "Go",
+	"Go-Bold.ttf":           "Go Bold",
+}
+
+func fontFamily(font string) string {
+	if family, ok := fontFamilies[font]; ok {
+		return family
+	}
+	name := strings.TrimSuffix(font, ".ttf")
+	return strings.TrimSuffix(name, "-Regular")
+}
+
+// svgAnchors maps text alignment to SVG text anchors. Under direction="rtl" start and end swap
+// sides, which mirrors the alignment along with the layout.
+var svgAnchors = map[string]string{
+	"":          "start",
+	AlignLeft:   "start",
+	AlignCentre: "middle",
+	AlignRight:  "end",
+}
+
+// renderSVG draws the template as an SVG document. Fonts are referenced from the static bucket
+// with @font-face so the vector card matches the raster one. The same fonts are loaded to fit text
+// to its MaxWidth and MaxHeight and to place it by its VAlign.
+func renderSVG(t *Template, data Data) ([]byte, error) {
+	var buf bytes.Buffer
+
+	c := newContext()
+	err := c.loadFonts(t.fonts()...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Right to left text is anchored at its right edge, so mirroring x mirrors the layout
+	direction := "ltr"
+	if data.Locale.RTL {
+		direction = "rtl"
+	}
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" direction="%s">`, t.Width, t.Height, t.Width, t.Height, direction)
+	buf.WriteString("\n<style>\n")
+	for _, font := range t.fonts() {
+		fmt.Fprintf(&buf, "@font-face { font-family: %q; src: url(\"https://storage.googleapis.com/%s/%s\"); }\n", fontFamily(font), util.StaticResourceBucket, font)
+	}
+	buf.WriteString("</style>\n")
+
+	background, err := data.Palette.colour(t.Background)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="%s"/>`+"\n", t.Width, t.Height, svgColour(background))
+
+	for _, p := range t.Panels {
+		if !data.panelShown(p) {
+			continue
+		}
+		colour, err := data.Palette.colour(p.Colour)
+		if err != nil {
+			return nil, err
+		}
+		if data.Locale.RTL {
+			p.X = t.Width - p.X - p.Width
+		}
+		fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", p.X, p.Y, p.Width, p.Height, svgColour(colour))
+	}
+
+	for _, text := range t.Texts {
+		value, ok := data.resolve(text)
+		if !ok {
+			continue
+		}
+		colour, err := data.textColour(text)
+		if err != nil {
+			return nil, err
+		}
+		size, e, err := c.fit(text, value)
+		if err != nil {
+			return nil, err
+		}
+		if data.Locale.RTL {
+			text.X = t.Width - text.X
+		}
+		// SVG text sits on its baseline; dominant-baseline isn't drawn the same everywhere, so
+		// the baseline is placed with the same font metrics as the raster card
+		y := float64(baseline(text, e)) / 64
+		fmt.Fprintf(&buf, `<text x="%d" y="%g" font-family="%s" font-size="%g" text-anchor="%s" fill="%s">`, text.X, math.Round(y*10)/10, fontFamily(text.Font), math.Round(size*10)/10, svgAnchors[text.Align], svgColour(colour))
+		if err := xml.EscapeText(&buf, []byte(value)); err != nil {
+			return nil, err
+		}
+		buf.WriteString("</text>\n")
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), nil
+}
+
+// svgColour converts a colour to an SVG paint, dropping the alpha into rgba() if present
+func svgColour(c color.NRGBA) string {
+	if c.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("rgba(%d,%d,%d,%.3f)", c.R, c.G, c.B, float64(c.A)/255)
+}
@@ -0,0 +1,399 @@
+package image
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+	"github.com/baely/weightloss-tracker/internal/integrations/gcs"
+	"github.com/baely/weightloss-tracker/internal/util"
+)
+
+const (
+	// PostTypeDaily is the post type of the daily stat card
+	PostTypeDaily = "daily"
+
+	// templateObjectFormat is the object name of a template in the static resource bucket
+	templateObjectFormat = "templates/%s.json"
+	// templateTTL is how long a loaded template is used before it's fetched again
+	templateTTL = 10 * time.Minute
+)
+
+// errNoTemplate is returned for a template that is neither in the bucket nor built in
+var errNoTemplate = errors.New("template not found")
+
+// PostTypes lists the post types rendered from templates
+var PostTypes = []string{PostTypeDaily, PostTypeSummary}
+
+//go:embed templates/*.json
+var builtinTemplates embed.FS
+
+// Template declares the layout of a card
+type Template struct {
+	Name       string  `json:"name"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Background string  `json:"background"`
+	Panels     []Panel `json:"panels"`
+	Texts      []Text  `json:"texts"`
+}
+
+// Panel is a filled rectangle
+type Panel struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Colour string `json:"colour"`
+	// Of names the field shown in the panel, so the panel is hidden with it
+	Of string `json:"of,omitempty"`
+}
+
+// Text is a string drawn aligned to X and, by default on the baseline, at Y. It is either literal Text, a
+// translated Label, or the value of a Data Field formatted with Format, or with the translation
+// of Label as the format.
+type Text struct {
+	Font   string  `json:"font"`
+	Size   float64 `json:"size"`
+	Colour string  `json:"colour"`
+	X      int     `json:"x"`
+	Y      int     `json:"y"`
+	Text   string  `json:"text,omitempty"`
+	Label  string  `json:"label,omitempty"`
+	Field  string  `json:"field,omitempty"`
+	Format string  `json:"format,omitempty"`
+	// HideZero leaves the text off when its field is zero
+	HideZero bool `json:"hideZero,omitempty"`
+	// Of names the field a literal label or unit describes, so it is hidden with the field
+	Of string `json:"of,omitempty"`
+	// Unit marks the text as the unit of the Of field, replaced when privacy changes the units
+	Unit bool `json:"unit,omitempty"`
+	// Threshold recolours the value by comparing it with another field
+	Threshold *Threshold `json:"threshold,omitempty"`
+	// Align is which end of the text sits at X: "left", the default, "centre" or "right"
+	Align string `json:"align,omitempty"`
+	// MaxWidth shrinks the text until it is no wider, 0 leaves it at Size
+	MaxWidth int `json:"maxWidth,omitempty"`
+	// VAlign is what sits at Y: "baseline", the default, or the "top", "middle" or "bottom" of
+	// the font's ascent and descent
+	VAlign string `json:"valign,omitempty"`
+	// MaxHeight shrinks the text until its ascent and descent are no taller, 0 leaves it at Size
+	MaxHeight int `json:"maxHeight,omitempty"`
+}
+
+const (
+	AlignLeft   = "left"
+	AlignCentre = "centre"
+	AlignRight  = "right"
+
+	VAlignBaseline = "baseline"
+	VAlignTop      = "top"
+	VAlignMiddle   = "middle"
+	VAlignBottom   = "bottom"
+)
+
+// Threshold colours a value by whether it is at or below, or above, another field, such as intake
+// against the intake target. It has no effect while the other field is zero.
+type Threshold struct {
+	Field string `json:"field"`
+	// Below is the colour of a value at or below the field, such as intake on target
+	Below string `json:"below,omitempty"`
+	Above string `json:"above,omitempty"`
+}
+
+type cachedTemplate struct {
+	// template is nil when the template doesn't exist
+	template *Template
+	loaded   time.Time
+}
+
+var templateCache = struct {
+	sync.Mutex
+	templates map[string]cachedTemplate
+}{templates: make(map[string]cachedTemplate)}
+
+// ParseTemplate decodes and validates a JSON template
+func ParseTemplate(b []byte) (*Template, error) {
+	var t Template
+	err := json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Validate checks that the template can be rendered
+func (t *Template) Validate() error {
+	if t.Width <= 0 || t.Height <= 0 {
+		return fmt.Errorf("template %q: invalid canvas size %dx%d", t.Name, t.Width, t.Height)
+	}
+	if err := checkColour(t.Background); err != nil {
+		return fmt.Errorf("template %q: background: %v", t.Name, err)
+	}
+
+	for i, p := range t.Panels {
+		if p.Width <= 0 || p.Height <= 0 {
+			return fmt.Errorf("template %q: panel %d: invalid size %dx%d", t.Name, i, p.Width, p.Height)
+		}
+		if err := checkColour(p.Colour); err != nil {
+			return fmt.Errorf("template %q: panel %d: %v", t.Name, i, err)
+		}
+	}
+
+	sample := Data{}.fields()
+	for i, p := range t.Panels {
+		if _, ok := sample[p.Of]; p.Of != "" && !ok {
+			return fmt.Errorf("template %q: panel %d: unknown field %q", t.Name, i, p.Of)
+		}
+	}
+	for i, text := range t.Texts {
+		if text.Font == "" {
+			return fmt.Errorf("template %q: text %d: missing font", t.Name, i)
+		}
+		if text.Size <= 0 {
+			return fmt.Errorf("template %q: text %d: invalid size %v", t.Name, i, text.Size)
+		}
+		if err := checkColour(text.Colour); err != nil {
+			return fmt.Errorf("template %q: text %d: %v", t.Name, i, err)
+		}
+		if _, ok := sample[text.Of]; text.Of != "" && !ok {
+			return fmt.Errorf("template %q: text %d: unknown field %q", t.Name, i, text.Of)
+		}
+		if th := text.Threshold; th != nil {
+			if _, ok := sample[th.Field].(float64); !ok || text.Field == "" {
+				return fmt.Errorf("template %q: text %d: threshold needs a numeric field and value", t.Name, i)
+			}
+			for _, c := range []string{th.Below, th.Above} {
+				if err := checkColour(c); c != "" && err != nil {
+					return fmt.Errorf("template %q: text %d: threshold: %v", t.Name, i, err)
+				}
+			}
+		}
+		if text.Text != "" && (text.Label != "" || text.Field != "") || text.Text == "" && text.Label == "" && text.Field == "" {
+			return fmt.Errorf("template %q: text %d: needs either text, or a label and/or field", t.Name, i)
+		}
+		if text.Align != "" && text.Align != AlignLeft && text.Align != AlignCentre && text.Align != AlignRight {
+			return fmt.Errorf("template %q: text %d: invalid align %q", t.Name, i, text.Align)
+		}
+		if text.MaxWidth < 0 {
+			return fmt.Errorf("template %q: text %d: invalid max width %d", t.Name, i, text.MaxWidth)
+		}
+		switch text.VAlign {
+		case "", VAlignBaseline, VAlignTop, VAlignMiddle, VAlignBottom:
+		default:
+			return fmt.Errorf("template %q: text %d: invalid valign %q", t.Name, i, text.VAlign)
+		}
+		if text.MaxHeight < 0 {
+			return fmt.Errorf("template %q: text %d: invalid max height %d", t.Name, i, text.MaxHeight)
+		}
+		if text.Label != "" && !hasLabel(text.Label) {
+			return fmt.Errorf("template %q: text %d: unknown label %q", t.Name, i, text.Label)
+		}
+		if text.Field == "" {
+			continue
+		}
+		if _, ok := sample[text.Field]; !ok {
+			return fmt.Errorf("template %q: text %d: unknown field %q", t.Name, i, text.Field)
+		}
+		if text.Label != "" {
+			text.Format = Locale{}.label(text.Label)
+		}
+		if s := text.value(sample, Locale{}); strings.Contains(s, "%!") {
+			return fmt.Errorf("template %q: text %d: bad format %q for field %q", t.Name, i, text.Format, text.Field)
+		}
+	}
+
+	return nil
+}
+
+// fonts returns the distinct fonts used by the template
+func (t *Template) fonts() []string {
+	seen := make(map[string]bool)
+	fonts := make([]string, 0)
+	for _, text := range t.Texts {
+		if !seen[text.Font] {
+			seen[text.Font] = true
+			fonts = append(fonts, text.Font)
+		}
+	}
+	return fonts
+}
+
+// value returns the string to draw for the text, with numbers and dates formatted for the locale
+func (t Text) value(fields map[string]interface{}, l Locale) string {
+	if t.Field == "" {
+		return t.Text
+	}
+
+	switch v := fields[t.Field].(type) {
+	case string:
+		if t.Format == "" {
+			return v
+		}
+		return fmt.Sprintf(t.Format, v)
+	case float64:
+		if t.Format == "" {
+			return l.sprintf("%.0f", v)
+		}
+		return l.sprintf(t.Format, v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return l.date(v)
+	}
+
+	return ""
+}
+
+// hidden reports whether the text should be left off the card
+func (t Text) hidden(fields map[string]interface{}) bool {
+	if !t.HideZero || t.Field == "" {
+		return false
+	}
+	switch v := fields[t.Field].(type) {
+	case string:
+		return v == ""
+	case float64:
+		return v == 0
+	case time.Time:
+		return v.IsZero()
+	}
+	return false
+}
+
+// TemplateFor returns the template the user has chosen for a post type, defaulting to the
+// template named after the post type
+func TemplateFor(settings database.Settings, postType string) (*Template, error) {
+	name := settings.Templates[postType]
+	if name == "" {
+		name = postType
+	}
+	return LoadTemplate(name)
+}
+
+// LoadTemplate returns the named template from the static resource bucket, falling back to the
+// built-in template of the same name. Templates are cached for templateTTL so edits in the
+// bucket are picked up without a redeploy.
+func LoadTemplate(name string) (*Template, error) {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+
+	cached, ok := templateCache.templates[name]
+	if ok && time.Since(cached.loaded) < templateTTL {
+		if cached.template == nil {
+			return nil, fmt.Errorf("template %q: %w", name, errNoTemplate)
+		}
+		return cached.template, nil
+	}
+
+	t, err := fetchTemplate(name)
+	if errors.Is(err, errNoTemplate) {
+		// Missing templates are cached too, as size variants are looked up on every render and
+		// most don't exist
+		templateCache.templates[name] = cachedTemplate{loaded: time.Now()}
+		return nil, err
+	}
+	if err != nil {
+		if ok && cached.template != nil {
+			fmt.Printf("error reloading template '%s', using cached copy: %v\n", name, err)
+			return cached.template, nil
+		}
+		return nil, err
+	}
+
+	templateCache.templates[name] = cachedTemplate{template: t, loaded: time.Now()}
+	return t, nil
+}
+
+func fetchTemplate(name string) (*Template, error) {
+	f, err := gcs.ReadFile(util.StaticResourceBucket, fmt.Sprintf(templateObjectFormat, name))
+	if err != nil && !gcs.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		return ParseTemplate(b)
+	}
+
+	return BuiltinTemplate(name)
+}
+
+// BuiltinTemplate returns the named template built into the binary, ignoring any override in
+// the bucket
+func BuiltinTemplate(name string) (*Template, error) {
+	b, err := builtinTemplates.ReadFile(fmt.Sprintf("templates/%s.json", name))
+	if err != nil {
+		return nil, fmt.Errorf("template %q: %w", name, errNoTemplate)
+	}
+	return ParseTemplate(b)
+}
+
+// ValidateTemplates loads every template the user's settings can select, so a broken template
+// is reported at startup rather than on the next render
+func ValidateTemplates(settings database.Settings) error {
+	var errs []string
+	for _, postType := range PostTypes {
+		t, err := TemplateFor(settings, postType)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, size := range Sizes {
+			if _, err = VariantFor(t, size); err != nil {
+				errs = append(errs, fmt.Sprintf("%s %s: %v", postType, size.Name, err))
+			}
+		}
+	}
+	for postType, name := range settings.Templates {
+		if _, err := LoadTemplate(name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", postType, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid templates: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// checkColour checks a template colour is a hex colour or a palette role
+func checkColour(s string) error {
+	_, err := Palette(nil).colour(s)
+	return err
+}
+
+// parseHex parses a colour in #rrggbb or #rrggbbaa form
+func parseHex(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 || !strings.HasPrefix(s, "#") {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid colour %q", s)
+	}
+
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
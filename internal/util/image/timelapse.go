@@ -0,0 +1,187 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"sort"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+
+	"github.com/baely/weightloss-tracker/internal/database"
+)
+
+const (
+	// TimelapseFilenameFormat is the object name for a timelapse, formatted with the month and kind
+	TimelapseFilenameFormat = "weightlog/timelapse/%s-%s.gif"
+
+	// TimelapseMaxBytes is the largest timelapse produced. Frames are shrunk until it fits.
+	TimelapseMaxBytes = 8 << 20
+	// timelapseMaxFrames caps the number of frames, so a long range can't blow the size budget
+	timelapseMaxFrames = 92
+	timelapseFrameSize = 540
+	timelapseMinSize   = 180
+	// timelapseDelay and timelapseHold are frame durations in hundredths of a second
+	timelapseDelay = 50
+	timelapseHold  = 250
+)
+
+// TimelapseKind is what a timelapse steps through
+type TimelapseKind string
+
+const (
+	// TimelapseCards steps through each day's card
+	TimelapseCards TimelapseKind = "cards"
+	// TimelapseChart draws the weight chart one day at a time
+	TimelapseChart TimelapseKind = "chart"
+)
+
+// GenerateCardTimelapse renders an animated GIF stepping through each day's card in order
+func GenerateCardTimelapse(t *Template, days []Data) ([]byte, error) {
+	if len(days) == 0 {
+		return nil, fmt.Errorf("no days to animate")
+	}
+
+	sorted := make([]Data, len(days))
+	copy(sorted, days)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Title < sorted[j].Title
+	})
+
+	frames := make([]*image.RGBA, 0, len(sorted))
+	for _, d := range sampleFrames(len(sorted)) {
+		img, err := rasterise(t, sorted[d])
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+	}
+
+	return encodeTimelapse(frames)
+}
+
+// GenerateChartTimelapse renders an animated GIF of the chart from start to end, drawing the
+// lines in one day per frame
+func GenerateChartTimelapse(docs []database.Document, start, end string, style Style) ([]byte, error) {
+	startDate, err := time.Parse("2006-01-02", start)
+	if err != nil {
+		return nil, err
+	}
+	endDate, err := time.Parse("2006-01-02", end)
+	if err != nil {
+		return nil, err
+	}
+	days := int(endDate.Sub(startDate).Hours()/24) + 1
+	if days < 1 {
+		return nil, fmt.Errorf("end %s is before start %s", end, start)
+	}
+
+	frames := make([]*image.RGBA, 0, days)
+	for _, d := range sampleFrames(days) {
+		img, err := drawChart(docs, end, days, d+1, style)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, img)
+	}
+
+	return encodeTimelapse(frames)
+}
+
+// sampleFrames returns the indices of n items to use as frames, evenly spaced and always
+// including the last
+func sampleFrames(n int) []int {
+	if n <= timelapseMaxFrames {
+		indices := make([]int, n)
+		for i := range indices {
+			indices[i] = i
+		}
+		return indices
+	}
+
+	indices := make([]int, timelapseMaxFrames)
+	for i := range indices {
+		indices[i] = i * (n - 1) / (timelapseMaxFrames - 1)
+	}
+	return indices
+}
+
+// encodeTimelapse encodes the frames as a looping GIF with a shared palette, shrinking the frames
+// until the result fits in TimelapseMaxBytes
+func encodeTimelapse(frames []*image.RGBA) ([]byte, error) {
+	p := timelapsePalette(frames)
+
+	for size := timelapseFrameSize; size >= timelapseMinSize; size = size * 3 / 4 {
+		anim := &gif.GIF{LoopCount: 0}
+		for i, frame := range frames {
+			b := frame.Bounds()
+			w, h := size, size*b.Dy()/b.Dx()
+			if b.Dy() > b.Dx() {
+				w, h = size*b.Dx()/b.Dy(), size
+			}
+
+			scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+			xdraw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), frame, b, xdraw.Src, nil)
+
+			paletted := image.NewPaletted(scaled.Bounds(), p)
+			draw.Draw(paletted, paletted.Bounds(), scaled, image.Point{}, draw.Src)
+
+			delay := timelapseDelay
+			if i == len(frames)-1 {
+				delay = timelapseHold
+			}
+			anim.Image = append(anim.Image, paletted)
+			anim.Delay = append(anim.Delay, delay)
+		}
+
+		var buf bytes.Buffer
+		err := gif.EncodeAll(&buf, anim)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() <= TimelapseMaxBytes {
+			return buf.Bytes(), nil
+		}
+	}
+
+	return nil, fmt.Errorf("timelapse of %d frames doesn't fit in %d bytes", len(frames), TimelapseMaxBytes)
+}
+
+// timelapsePalette picks the 256 most common colours across the frames. Ties are broken by
+// colour value so the same frames always give the same palette.
+func timelapsePalette(frames []*image.RGBA) color.Palette {
+	counts := make(map[color.RGBA]int)
+	for _, frame := range frames {
+		b := frame.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y += 2 {
+			for x := b.Min.X; x < b.Max.X; x += 2 {
+				counts[frame.RGBAAt(x, y)]++
+			}
+		}
+	}
+
+	colours := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		colours = append(colours, c)
+	}
+	sort.Slice(colours, func(i, j int) bool {
+		a, b := colours[i], colours[j]
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return uint32(a.R)<<24|uint32(a.G)<<16|uint32(a.B)<<8|uint32(a.A) < uint32(b.R)<<24|uint32(b.G)<<16|uint32(b.B)<<8|uint32(b.A)
+	})
+
+	if len(colours) > 256 {
+		colours = colours[:256]
+	}
+	p := make(color.Palette, len(colours))
+	for i, c := range colours {
+		p[i] = c
+	}
+	return p
+}
@@ -0,0 +1,379 @@
+package image
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// A minimal lossless WebP (VP8L) encoder. It uses no transforms and no colour cache, and finds
+// backward references only against the previous pixel and the pixel above, which is enough for
+// flat-colour cards to compress well.
+
+const (
+	vp8lSignature    = 0x2f
+	vp8lNumLiterals  = 256
+	vp8lNumLengths   = 24
+	vp8lNumDistances = 40
+	vp8lMaxLength    = 4096
+	vp8lMinLength    = 3
+	// vp8lPlaneCodes is the number of distance codes reserved for the 2D neighbourhood map.
+	// Distance codes above it are plain pixel distances.
+	vp8lPlaneCodes  = 120
+	vp8lMaxCodeBits = 15
+	vp8lMaxCLBits   = 7
+)
+
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nbits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nbits > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nbits = 0, 0
+	}
+	return w.buf
+}
+
+// vp8lSymbol is either a literal ARGB pixel or a backward reference
+type vp8lSymbol struct {
+	argb     uint32
+	length   int
+	distance int
+}
+
+// prefixEncode splits a length or distance value into its prefix symbol and extra bits
+func prefixEncode(value int) (prefix int, extraBits uint, extra uint32) {
+	v := value - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+	highest := 0
+	for (v >> (highest + 1)) != 0 {
+		highest++
+	}
+	second := (v >> (highest - 1)) & 1
+	extraBits = uint(highest - 1)
+	return 2*highest + second, extraBits, uint32(v & ((1 << extraBits) - 1))
+}
+
+type huffmanCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (h huffmanCode) write(w *bitWriter, symbol int) {
+	w.write(h.codes[symbol], uint(h.lengths[symbol]))
+}
+
+// buildHuffman returns length-limited canonical codes for the histogram. At least two symbols
+// always get a code, which keeps the decoder away from its single-symbol special cases.
+func buildHuffman(counts []int, maxBits int) huffmanCode {
+	hist := make([]int, len(counts))
+	copy(hist, counts)
+
+	used := 0
+	for _, c := range hist {
+		if c > 0 {
+			used++
+		}
+	}
+	for i := 0; used < 2 && i < len(hist); i++ {
+		if hist[i] == 0 {
+			hist[i] = 1
+			used++
+		}
+	}
+
+	var lengths []int
+	for {
+		lengths = huffmanLengths(hist)
+		longest := 0
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= maxBits {
+			break
+		}
+		for i, c := range hist {
+			if c > 0 {
+				hist[i] = (c + 1) / 2
+			}
+		}
+	}
+
+	return huffmanCode{lengths: lengths, codes: canonicalCodes(lengths)}
+}
+
+type huffmanNode struct {
+	count  int
+	symbol int
+	left   *huffmanNode
+	right  *huffmanNode
+}
+
+type nodeHeap []*huffmanNode
+
+func (h nodeHeap) Len() int { return len(h) }
+func (h nodeHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h nodeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *nodeHeap) Push(x interface{}) { *h = append(*h, x.(*huffmanNode)) }
+func (h *nodeHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func huffmanLengths(counts []int) []int {
+	lengths := make([]int, len(counts))
+
+	h := make(nodeHeap, 0)
+	for s, c := range counts {
+		if c > 0 {
+			h = append(h, &huffmanNode{count: c, symbol: s})
+		}
+	}
+	heap.Init(&h)
+
+	next := len(counts)
+	for h.Len() > 1 {
+		a := heap.Pop(&h).(*huffmanNode)
+		b := heap.Pop(&h).(*huffmanNode)
+		heap.Push(&h, &huffmanNode{count: a.count + b.count, symbol: next, left: a, right: b})
+		next++
+	}
+
+	var walk func(n *huffmanNode, depth int)
+	walk = func(n *huffmanNode, depth int) {
+		if n.left == nil {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	if h.Len() == 1 {
+		walk(h[0], 0)
+	}
+
+	return lengths
+}
+
+// canonicalCodes assigns canonical codes to the lengths, bit-reversed for an LSB-first stream
+func canonicalCodes(lengths []int) []uint32 {
+	codes := make([]uint32, len(lengths))
+
+	symbols := make([]int, 0, len(lengths))
+	for s, l := range lengths {
+		if l > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return lengths[symbols[i]] < lengths[symbols[j]]
+	})
+
+	code, prevLen := uint32(0), 0
+	for _, s := range symbols {
+		l := lengths[s]
+		code <<= uint(l - prevLen)
+		prevLen = l
+
+		var reversed uint32
+		for i := 0; i < l; i++ {
+			reversed |= ((code >> uint(i)) & 1) << uint(l-1-i)
+		}
+		codes[s] = reversed
+		code++
+	}
+
+	return codes
+}
+
+// writeHuffmanCode writes the code lengths of a prefix code using a normal code length code
+func writeHuffmanCode(w *bitWriter, h huffmanCode) {
+	clCounts := make([]int, 19)
+	for _, l := range h.lengths {
+		clCounts[l]++
+	}
+	cl := buildHuffman(clCounts, vp8lMaxCLBits)
+
+	numCodes := 19
+	for numCodes > 4 && cl.lengths[vp8lCodeLengthOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+
+	w.write(0, 1) // normal code length code
+	w.write(uint32(numCodes-4), 4)
+	for i := 0; i < numCodes; i++ {
+		w.write(uint32(cl.lengths[vp8lCodeLengthOrder[i]]), 3)
+	}
+	w.write(0, 1) // max_symbol is the alphabet size
+
+	for _, l := range h.lengths {
+		cl.write(w, l)
+	}
+}
+
+// findSymbols turns the pixels into literals and backward references to the previous pixel or
+// the pixel above
+func findSymbols(argb []uint32, width int) []vp8lSymbol {
+	symbols := make([]vp8lSymbol, 0, len(argb)/4)
+
+	matchLen := func(i, distance int) int {
+		if i < distance {
+			return 0
+		}
+		n := 0
+		for i+n < len(argb) && n < vp8lMaxLength && argb[i+n] == argb[i+n-distance] {
+			n++
+		}
+		return n
+	}
+
+	for i := 0; i < len(argb); {
+		length, distance := matchLen(i, 1), 1
+		if above := matchLen(i, width); above > length {
+			length, distance = above, width
+		}
+
+		if length >= vp8lMinLength {
+			symbols = append(symbols, vp8lSymbol{length: length, distance: distance})
+			i += length
+			continue
+		}
+
+		symbols = append(symbols, vp8lSymbol{argb: argb[i]})
+		i++
+	}
+
+	return symbols
+}
+
+// EncodeWebP writes the image as a lossless WebP
+func EncodeWebP(out io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > 1<<14 || height > 1<<14 {
+		return fmt.Errorf("webp: invalid image size %dx%d", width, height)
+	}
+
+	argb := make([]uint32, 0, width*height)
+	alpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				alpha = true
+			}
+			argb = append(argb, uint32(c.A)<<24|uint32(c.R)<<16|uint32(c.G)<<8|uint32(c.B))
+		}
+	}
+
+	symbols := findSymbols(argb, width)
+
+	greenCounts := make([]int, vp8lNumLiterals+vp8lNumLengths)
+	redCounts := make([]int, vp8lNumLiterals)
+	blueCounts := make([]int, vp8lNumLiterals)
+	alphaCounts := make([]int, vp8lNumLiterals)
+	distCounts := make([]int, vp8lNumDistances)
+	for _, s := range symbols {
+		if s.length == 0 {
+			greenCounts[(s.argb>>8)&0xff]++
+			redCounts[(s.argb>>16)&0xff]++
+			blueCounts[s.argb&0xff]++
+			alphaCounts[s.argb>>24]++
+			continue
+		}
+		lp, _, _ := prefixEncode(s.length)
+		dp, _, _ := prefixEncode(s.distance + vp8lPlaneCodes)
+		greenCounts[vp8lNumLiterals+lp]++
+		distCounts[dp]++
+	}
+
+	green := buildHuffman(greenCounts, vp8lMaxCodeBits)
+	red := buildHuffman(redCounts, vp8lMaxCodeBits)
+	blue := buildHuffman(blueCounts, vp8lMaxCodeBits)
+	alphaCode := buildHuffman(alphaCounts, vp8lMaxCodeBits)
+	dist := buildHuffman(distCounts, vp8lMaxCodeBits)
+
+	w := &bitWriter{}
+	w.write(vp8lSignature, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if alpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3) // version
+	w.write(0, 1) // no transforms
+	w.write(0, 1) // no colour cache
+	w.write(0, 1) // no meta prefix codes
+
+	for _, h := range []huffmanCode{green, red, blue, alphaCode, dist} {
+		writeHuffmanCode(w, h)
+	}
+
+	for _, s := range symbols {
+		if s.length == 0 {
+			green.write(w, int((s.argb>>8)&0xff))
+			red.write(w, int((s.argb>>16)&0xff))
+			blue.write(w, int(s.argb&0xff))
+			alphaCode.write(w, int(s.argb>>24))
+			continue
+		}
+		lp, lBits, lExtra := prefixEncode(s.length)
+		green.write(w, vp8lNumLiterals+lp)
+		w.write(lExtra, lBits)
+		dp, dBits, dExtra := prefixEncode(s.distance + vp8lPlaneCodes)
+		dist.write(w, dp)
+		w.write(dExtra, dBits)
+	}
+
+	data := w.bytes()
+	chunkSize := len(data)
+	padded := chunkSize + chunkSize&1
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+8+padded))
+	buf.WriteString("WEBP")
+	buf.WriteString("VP8L")
+	binary.Write(&buf, binary.LittleEndian, uint32(chunkSize))
+	buf.Write(data)
+	if chunkSize&1 == 1 {
+		buf.WriteByte(0)
+	}
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
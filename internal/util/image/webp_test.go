@@ -0,0 +1,107 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// TestEncodeWebPRoundTrip decodes the encoder's output with x/image/webp and checks every pixel
+// survives, on images shaped like cards (flat panels, so backward references are used) and on
+// noise (so literals and every code length are)
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"1x1", flat(1, 1, color.NRGBA{R: 0xc2, G: 0x18, B: 0x5b, A: 0xff})},
+		{"1x1 transparent", flat(1, 1, color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x40})},
+		{"3x5 noise", noise(3, 5, 1, false)},
+		{"17x9 noise with alpha", noise(17, 9, 2, true)},
+		{"101x37 panels", panels(101, 37)},
+		{"255x1 noise", noise(255, 1, 3, false)},
+		{"1x255 noise", noise(1, 255, 4, false)},
+		{"1080x1080 panels", panels(1080, 1080)},
+		{"1080x1080 noise", noise(1080, 1080, 5, false)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, tt.img); err != nil {
+				t.Fatalf("encoding: %v", err)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+
+			want, got := tt.img.Bounds(), decoded.Bounds()
+			if want.Dx() != got.Dx() || want.Dy() != got.Dy() {
+				t.Fatalf("decoded %dx%d, want %dx%d", got.Dx(), got.Dy(), want.Dx(), want.Dy())
+			}
+
+			for y := 0; y < want.Dy(); y++ {
+				for x := 0; x < want.Dx(); x++ {
+					w := color.NRGBAModel.Convert(tt.img.At(want.Min.X+x, want.Min.Y+y))
+					g := color.NRGBAModel.Convert(decoded.At(got.Min.X+x, got.Min.Y+y))
+					if w != g {
+						t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPInvalidSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 10))); err == nil {
+		t.Error("encoding an empty image succeeded")
+	}
+}
+
+func flat(width, height int, c color.NRGBA) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// panels draws a background with a few flat rectangles and some stripes, like a card
+func panels(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			switch {
+			case y < height/4:
+				c = color.NRGBA{R: 0xe8, G: 0xe8, B: 0xe8, A: 0xff}
+			case x > width/10 && x < width/2 && y > height/3 && y < height*2/3:
+				c = color.NRGBA{R: 0xc2, G: 0x18, B: 0x5b, A: 0xff}
+			case (x+y)%7 == 0:
+				c = color.NRGBA{R: 0x21, G: 0x21, B: 0x21, A: 0xff}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func noise(width, height int, seed int64, alpha bool) image.Image {
+	r := rand.New(rand.NewSource(seed))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	r.Read(img.Pix)
+	if !alpha {
+		for i := 3; i < len(img.Pix); i += 4 {
+			img.Pix[i] = 0xff
+		}
+	}
+	return img
+}